@@ -16,20 +16,33 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/sivel/gohaste/progress"
+	"github.com/sivel/gohaste/providers"
+	"github.com/sivel/gohaste/providers/azure"
+	"github.com/sivel/gohaste/providers/b2"
+	"github.com/sivel/gohaste/providers/gcs"
+	"github.com/sivel/gohaste/providers/oss"
+	"github.com/sivel/gohaste/providers/rackspace"
+	"github.com/sivel/gohaste/providers/ratelimit"
+	"github.com/sivel/gohaste/providers/retry"
+	"github.com/sivel/gohaste/providers/s3"
 )
 
 type Walker struct {
@@ -44,256 +57,208 @@ func (w *Walker) Walk(path string, info os.FileInfo, err error) error {
 	return nil
 }
 
-type AuthContainer struct {
-	Auth Auth `json:"auth"`
-}
-
-type Auth struct {
-	PasswordCredentials *PasswordCredentials `json:"passwordCredentials,omitempty"`
-	ApiKeyCredentials   *ApiKeyCredentials   `json:"RAX-KSKEY:apiKeyCredentials,omitempty"`
-	TenantId            string               `json:"tenantId,omitempty"`
-	TenantName          string               `json:"tenantName,omitempty"`
-}
-
-type PasswordCredentials struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-}
-
-type ApiKeyCredentials struct {
-	Username string `json:"username"`
-	ApiKey   string `json:"apiKey"`
-}
-
-type Tokens struct {
-	Access Access `json:"access"`
-}
-
-type Access struct {
-	Token          Token `json:"token"`
-	ServiceCatalog []CatalogEntry
-}
-
-type CatalogEntry struct {
-	Name, Type string
-	Endpoints  []EntryEndpoint
-}
-
-type EntryEndpoint struct {
-	Region, TenantId                    string
-	PublicURL, InternalURL              string
-	VersionId, VersionInfo, VersionList string
+// newProvider builds the providers.StorageProvider named by provider,
+// reading its credentials from the command-line flags or, failing that,
+// the backend's usual environment variables.
+func newProvider(provider, username, password, region string) providers.StorageProvider {
+	switch provider {
+	case "rackspace":
+		return rackspace.New(username, password, region)
+	case "s3":
+		accessKeyId := flagOrEnv(username, "AWS_ACCESS_KEY_ID")
+		secretAccessKey := flagOrEnv(password, "AWS_SECRET_ACCESS_KEY")
+		return s3.New(accessKeyId, secretAccessKey, flagOrEnv(region, "AWS_REGION"))
+	case "gcs":
+		return gcs.New(flagOrEnv(username, "GOOGLE_APPLICATION_CREDENTIALS"))
+	case "azure":
+		return azure.New(flagOrEnv(username, "AZURE_STORAGE_ACCOUNT"), flagOrEnv(password, "AZURE_STORAGE_KEY"))
+	case "b2":
+		return b2.New(flagOrEnv(username, "B2_KEY_ID"), flagOrEnv(password, "B2_APPLICATION_KEY"))
+	case "oss":
+		accessKeyId := flagOrEnv(username, "OSS_ACCESS_KEY_ID")
+		accessKeySecret := flagOrEnv(password, "OSS_ACCESS_KEY_SECRET")
+		return oss.New(accessKeyId, accessKeySecret, flagOrEnv(region, "OSS_ENDPOINT"))
+	default:
+		log.Fatal(fmt.Sprintf("%s is not a supported provider", provider))
+		return nil
+	}
 }
 
-type Token struct {
-	Id      string `json:"id"`
-	Expires string `json:"expires"`
+// flagOrEnv returns value if it was set on the command line, otherwise
+// falls back to the named environment variable.
+func flagOrEnv(value, envVar string) string {
+	if len(value) > 0 {
+		return value
+	}
+	return os.Getenv(envVar)
 }
 
-type CloudFiles struct {
-	Username  string
-	ApiKey    string
-	Token     string
-	Endpoint  string
-	Container string
-	Region    string
+// counters tallies how a run went, for the summary printed on exit.
+type counters struct {
+	successes int64
+	failures  int64
 }
 
-// Auth will authenticate to CloudFiles
-func (c *CloudFiles) Auth() {
-	var tokens *Tokens
-
-	auth := &AuthContainer{
-		Auth: Auth{
-			ApiKeyCredentials: &ApiKeyCredentials{
-				Username: c.Username,
-				ApiKey:   c.ApiKey,
-			},
-		},
-	}
-	body, _ := json.Marshal(auth)
+func (c *counters) ok()   { atomic.AddInt64(&c.successes, 1) }
+func (c *counters) fail() { atomic.AddInt64(&c.failures, 1) }
 
-	res, err := http.Post("https://identity.api.rackspacecloud.com/v2.0/tokens", "application/json", bytes.NewBuffer(body))
-	defer res.Body.Close()
-	if res.StatusCode != 200 || err != nil {
-		log.Fatal("Unable to authenticate")
-	}
+// Upload is a goroutine that uploads files provided by a channel to container
+func Upload(ctx context.Context, p providers.StorageProvider, container string, thread int, ci chan string, wg *sync.WaitGroup, BasePath string, SegmentSize int64, limiter *ratelimit.Limiter, cnt *counters, reporter progress.Reporter) {
+	log.Printf("creating uploader thread: %03d", thread)
 
-	resBody, _ := ioutil.ReadAll(res.Body)
+	defer wg.Done()
 
-	json.Unmarshal(resBody, &tokens)
+	for path := range ci {
+		ObjPath := strings.TrimPrefix(strings.Replace(path, BasePath, "", 1), "/")
 
-	for _, service := range tokens.Access.ServiceCatalog {
-		if service.Type == "object-store" {
-			for _, endpoint := range service.Endpoints {
-				if endpoint.Region == c.Region {
-					c.Endpoint = endpoint.PublicURL
-					break
-				}
-			}
-			break
+		info, err := os.Stat(path)
+		if err != nil {
+			reporter.Error(thread, ObjPath, err)
+			cnt.fail()
+			continue
 		}
-	}
-
-	if len(c.Endpoint) == 0 {
-		log.Fatal(fmt.Sprintf("No PublicURL found for object-store in region %s", c.Region))
-	}
+		reporter.Start(thread, ObjPath, info.Size())
 
-	c.Token = tokens.Access.Token.Id
-}
-
-func (c *CloudFiles) ListObjects(ci chan string) {
-	var resBody []byte
-	var marker string
-	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/%s", c.Endpoint, c.Container), nil)
-	req.Header.Set("X-Auth-Token", c.Token)
-	req.Header.Set("Accept", "text/plain")
-	client := &http.Client{}
-	res, err := client.Do(req)
-	if err != nil {
-		log.Fatal("err != nil")
-		log.Fatal(err)
-	} else if res.StatusCode != 200 {
-		resBody, _ = ioutil.ReadAll(res.Body)
-		fmt.Println(string(resBody))
-		log.Fatal(res.StatusCode)
-	}
-	defer res.Body.Close()
-	resBody, _ = ioutil.ReadAll(res.Body)
-	for _, object := range strings.Split(strings.TrimSpace(string(resBody)), "\n") {
-		if len(strings.TrimSpace(object)) == 0 {
+		if multipart, ok := p.(providers.MultipartUploader); ok && info.Size() >= SegmentSize {
+			if err := multipart.PutLargeObject(ctx, container, ObjPath, path, info.Size(), SegmentSize, 4); err != nil {
+				reporter.Error(thread, ObjPath, err)
+				cnt.fail()
+				continue
+			}
+			reporter.Done(thread, ObjPath)
+			cnt.ok()
 			continue
 		}
-		ci <- object
-		marker = object
-	}
-	go func(ci chan string, resBody []byte, marker string) {
-		for len(resBody) > 0 {
-			req.URL, _ = url.ParseRequestURI(fmt.Sprintf("%s/%s?marker=%s", c.Endpoint, c.Container, marker))
-			res, err := client.Do(req)
+
+		getBody := func() (io.ReadCloser, error) {
+			file, err := os.Open(path)
 			if err != nil {
-				log.Fatal(err)
-			}
-			resBody, _ = ioutil.ReadAll(res.Body)
-			for _, object := range strings.Split(strings.TrimSpace(string(resBody)), "\n") {
-				if len(strings.TrimSpace(object)) == 0 {
-					continue
-				}
-				ci <- object
-				marker = object
+				return nil, err
 			}
-			res.Body.Close()
+			return ioutil.NopCloser(progress.Reader(ratelimit.Reader(file, limiter), reporter, thread, ObjPath)), nil
 		}
-		close(ci)
-	}(ci, resBody, marker)
-}
 
-// CreateContainer ensures that a container exists
-func (c *CloudFiles) CreateContainer() {
-	req, _ := http.NewRequest("PUT", fmt.Sprintf("%s/%s", c.Endpoint, c.Container), bytes.NewBuffer([]byte{}))
-	req.Header.Set("X-Auth-Token", c.Token)
-	client := &http.Client{}
-	res, _ := client.Do(req)
-	fmt.Println(res.StatusCode)
+		if err := p.PutObject(ctx, container, ObjPath, getBody); err != nil {
+			reporter.Error(thread, ObjPath, err)
+			cnt.fail()
+			continue
+		}
+		reporter.Done(thread, ObjPath)
+		cnt.ok()
+	}
+	log.Printf("thread %03d: exiting", thread)
 }
 
-// Upload is a goroutine that uploads files provided by a channel to a CloudFiles container
-func (c *CloudFiles) Upload(thread int, ci chan string, wg *sync.WaitGroup, BasePath string) {
-	fmt.Printf("Creating uploader thread: %03d\n", thread)
+// Delete is a goroutine that deletes objects named on a channel from container
+func Delete(ctx context.Context, p providers.StorageProvider, container string, thread int, ci chan string, wg *sync.WaitGroup, cnt *counters, reporter progress.Reporter) {
+	log.Printf("creating deleter thread: %03d", thread)
 
 	defer wg.Done()
 
-	client := &http.Client{}
-	req, _ := http.NewRequest("PUT", "", nil)
-	req.Header.Set("X-Auth-Token", c.Token)
-
 	for path := range ci {
-		ObjPath := strings.TrimPrefix(strings.Replace(path, BasePath, "", 1), "/")
-		fmt.Printf("Thread %03d: uploading %s\n", thread, ObjPath)
-
-		file, err := os.Open(path)
-		if err != nil {
-			log.Print(fmt.Printf("%s\n", err))
-			continue
-		}
-
-		req.URL, _ = url.ParseRequestURI(fmt.Sprintf("%s/%s/%s", c.Endpoint, c.Container, ObjPath))
-		req.Body = file
-		res, err := client.Do(req)
-		if err != nil {
-			log.Print(fmt.Printf("%s\n", err))
+		reporter.Start(thread, path, 0)
+		if err := p.DeleteObject(ctx, container, path); err != nil {
+			reporter.Error(thread, path, err)
+			cnt.fail()
 			continue
 		}
-		file.Close()
-		res.Body.Close()
-		fmt.Printf("Thread %03d: upload complete for %s\n", thread, ObjPath)
+		reporter.Done(thread, path)
+		cnt.ok()
 	}
-	fmt.Printf("Thread %03d: exiting\n", thread)
+	log.Printf("thread %03d: exiting", thread)
 }
 
-func (c *CloudFiles) Delete(thread int, ci chan string, wg *sync.WaitGroup) {
-	fmt.Printf("Creating deleter thread: %03d\n", thread)
-
-	defer wg.Done()
-
-	client := &http.Client{}
-	req, _ := http.NewRequest("DELETE", "", nil)
-	req.Header.Set("X-Auth-Token", c.Token)
-
-	for path := range ci {
-		fmt.Printf("Thread %03d: deleting %s\n", thread, path)
-		req.URL, _ = url.ParseRequestURI(fmt.Sprintf("%s/%s/%s", c.Endpoint, c.Container, path))
-		res, err := client.Do(req)
-		if err != nil {
-			log.Print(fmt.Printf("%s\n", err))
-			continue
+// looksLikeMD5 reports whether etag has the shape of a hex-encoded MD5
+// (32 hex digits), the only shape Download trusts as a content hash to
+// verify against. Not every backend's ETag is a content hash (Azure's
+// is an opaque version token, for instance), so this is a best-effort
+// filter rather than a per-provider capability check.
+func looksLikeMD5(etag string) bool {
+	if len(etag) != 32 {
+		return false
+	}
+	for _, r := range etag {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f' || r >= 'A' && r <= 'F') {
+			return false
 		}
-		res.Body.Close()
-		fmt.Printf("Thread %03d: delete complete for %s\n", thread, path)
 	}
-	fmt.Printf("Thread %03d: exiting\n", thread)
+	return true
 }
 
-func (c *CloudFiles) Download(thread int, ci chan string, wg *sync.WaitGroup, BasePath string) {
-	fmt.Printf("Creating downloader thread: %03d\n", thread)
+// Download is a goroutine that downloads objects named on a channel from
+// container. Each object is written to a ".part" file and renamed into
+// place only once it's fully and, when etags has a usable entry for it,
+// correctly received; a failed or corrupted download never leaves a
+// truncated file at the final path. The ETag check is skipped for
+// objects GetObject reports as an SLO/DLO manifest (see
+// providers.ManifestBody), since a manifest's ETag is a hash of its
+// segments' ETags, not of the reassembled content.
+func Download(ctx context.Context, p providers.StorageProvider, container string, thread int, ci chan string, wg *sync.WaitGroup, BasePath string, limiter *ratelimit.Limiter, cnt *counters, etags map[string]string, sizes map[string]int64, reporter progress.Reporter) {
+	log.Printf("creating downloader thread: %03d", thread)
 
 	defer wg.Done()
 
-	client := &http.Client{}
-	req, _ := http.NewRequest("GET", "", nil)
-	req.Header.Set("X-Auth-Token", c.Token)
-
 	for path := range ci {
-		fmt.Printf("Thread %03d: downloading %s\n", thread, path)
+		reporter.Start(thread, path, sizes[path])
 		FullPath := filepath.Join(BasePath, path)
-		req.URL, _ = url.ParseRequestURI(fmt.Sprintf("%s/%s/%s", c.Endpoint, c.Container, path))
-		res, err := client.Do(req)
+		PartPath := FullPath + ".part"
+
+		body, err := p.GetObject(ctx, container, path)
 		if err != nil {
-			log.Print(fmt.Printf("%s\n", err))
+			reporter.Error(thread, path, err)
+			cnt.fail()
 			continue
 		}
+		isManifest := false
+		if m, ok := body.(providers.ManifestBody); ok {
+			isManifest = m.IsManifest()
+		}
 
 		os.MkdirAll(filepath.Dir(FullPath), 0755)
-		file, err := os.Create(FullPath)
+		file, err := os.Create(PartPath)
 		if err != nil {
-			log.Print(fmt.Printf("%s\n", err))
+			body.Close()
+			reporter.Error(thread, path, err)
+			cnt.fail()
 			continue
 		}
 
-		_, err = io.Copy(file, res.Body)
+		md5Sum := md5.New()
+		_, err = io.Copy(file, progress.Reader(io.TeeReader(ratelimit.Reader(body, limiter), md5Sum), reporter, thread, path))
+		body.Close()
+		file.Close()
 		if err != nil {
-			log.Print(fmt.Printf("%s\n", err))
+			os.Remove(PartPath)
+			reporter.Error(thread, path, err)
+			cnt.fail()
 			continue
 		}
-		file.Close()
-		res.Body.Close()
-		fmt.Printf("Thread %03d: download complete for %s\n", thread, path)
+
+		if etag := etags[path]; !isManifest && looksLikeMD5(etag) {
+			if got := hex.EncodeToString(md5Sum.Sum(nil)); got != strings.ToLower(etag) {
+				os.Remove(PartPath)
+				reporter.Error(thread, path, fmt.Errorf("checksum mismatch: got %s, want %s", got, etag))
+				cnt.fail()
+				continue
+			}
+		}
+
+		if err := os.Rename(PartPath, FullPath); err != nil {
+			os.Remove(PartPath)
+			reporter.Error(thread, path, err)
+			cnt.fail()
+			continue
+		}
+		reporter.Done(thread, path)
+		cnt.ok()
 	}
-	fmt.Printf("Thread %03d: exiting\n", thread)
+	log.Printf("thread %03d: exiting", thread)
 }
 
 func Usage() {
 	fmt.Printf(`usage: %s [options] {delete,upload,download} source [destination]
+       %s [options] sync src-url dst-url
+       %s [options] verify container local-path [prefix]
 
 Delete:
     gohaste [options] delete my-container
@@ -304,34 +269,103 @@ Upload:
 Download:
     gohaste [options] download my-container /path/to/files
 
+Sync:
+    gohaste [options] sync cf://my-container/prefix s3://my-bucket/prefix
+
+    src-url and dst-url are provider://container/prefix, where provider
+    is one of cf (Rackspace CloudFiles), s3, gs (GCS), az (Azure), b2,
+    or oss. Credentials for each side are read from that provider's
+    usual environment variables.
+
+Verify:
+    gohaste [options] verify my-container /path/to/files [prefix]
+
+    Compares every object in my-container (optionally limited to names
+    starting with prefix) against the matching file under local-path,
+    without transferring bytes when size and modification time already
+    agree. Mismatched or missing files are reported; nothing is
+    downloaded or changed.
+
+    -log-format=json switches the progress output on delete, upload,
+    download and sync from the default (a live TTY display, or a plain
+    log when stdout isn't a terminal) to a newline-delimited JSON event
+    stream suitable for consumption by other tools. verify always prints
+    plain text.
+
 options:
-`, path.Base(os.Args[0]))
+`, path.Base(os.Args[0]), path.Base(os.Args[0]), path.Base(os.Args[0]))
 	flag.PrintDefaults()
 	os.Exit(2)
 }
 
 func main() {
 	var w Walker
+	var Provider string
 	var Username string
 	var Password string
 	var Region string
 	var Concurrency int
+	var DeleteExtra bool
+	var DryRun bool
+	var SegmentSize int64
+	var RateLimitMbps float64
+	var LogFormat string
 
 	flag.Usage = Usage
+	flag.StringVar(&Provider, "provider", "rackspace", "Storage provider to use: rackspace, s3, gcs, azure, b2, oss. Defaults to rackspace")
 	flag.StringVar(&Username, "username", os.Getenv("OS_USERNAME"), "Username to authenticate with. Defaults to OS_USERNAME")
 	flag.StringVar(&Password, "password", os.Getenv("OS_PASSWORD"), "Password to authenticate with. Defaults to OS_PASSWORD")
 	flag.StringVar(&Region, "region", os.Getenv("OS_REGION_NAME"), "Password to authenticate with. Defaults to OS_REGION_NAME")
 	flag.IntVar(&Concurrency, "concurrency", 10, "Number of cuncurrent operations. Defaults to 10")
+	flag.BoolVar(&DeleteExtra, "delete", false, "With sync, delete destination objects that are no longer present in the source")
+	flag.BoolVar(&DryRun, "dry-run", false, "With sync, print what would be copied/deleted without transferring anything")
+	flag.Int64Var(&SegmentSize, "segment-size", 100*1024*1024, "Files at or above this size are uploaded as segmented large objects, where the provider supports it. Defaults to 100 MiB")
+	flag.Float64Var(&RateLimitMbps, "rate-limit-mbps", 0, "Cap aggregate upload and download throughput to this many megabits/sec. Segmented large-object uploads and sync are not throttled. Defaults to unlimited")
+	flag.StringVar(&LogFormat, "log-format", "", "Progress output format: 'json' for a newline-delimited JSON event stream. Defaults to a live TTY display, or a plain log when stdout isn't a terminal")
 	flag.Parse()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		log.Print("received interrupt, cancelling in-flight operations")
+		cancel()
+	}()
+	defer cancel()
+
 	Operation := strings.ToLower(flag.Arg(0))
 	Src := flag.Arg(1)
 	Dest := flag.Arg(2)
 
-	if len(Username) == 0 || len(Password) == 0 || len(Region) == 0 || len(Operation) == 0 || len(Src) == 0 {
+	if len(Operation) == 0 || len(Src) == 0 {
 		Usage()
 	}
 
+	if Operation == "sync" {
+		if len(Dest) == 0 {
+			log.Fatal("'dst-url' is a required argument for 'sync'")
+		}
+		if err := runSync(ctx, Src, Dest, Concurrency, DeleteExtra, DryRun, LogFormat); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if Operation == "verify" {
+		if len(Dest) == 0 {
+			log.Fatal("'local-path' is a required argument for 'verify'")
+		}
+		p := newProvider(Provider, Username, Password, Region)
+		if err := p.Auth(ctx); err != nil {
+			log.Fatal(err)
+		}
+		if err := runVerify(ctx, p, Src, Dest, flag.Arg(3)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if Operation != "upload" && Operation != "download" && Operation != "delete" {
 		log.Fatal(fmt.Sprintf("%s not a supported operation", Operation))
 	}
@@ -340,38 +374,73 @@ func main() {
 		log.Fatal("'destination' is a required argument for 'upload' and 'download'")
 	}
 
-	ci := make(chan string)
+	ci := make(chan string, Concurrency)
 	wg := new(sync.WaitGroup)
+	limiter := ratelimit.New(RateLimitMbps)
+	cnt := &counters{}
 
-	c := CloudFiles{
-		Username: Username,
-		ApiKey:   Password,
-		Region:   Region,
+	p := newProvider(Provider, Username, Password, Region)
+	if err := p.Auth(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	var container string
+	var objects []providers.Object
+	etags := map[string]string{}
+	sizes := map[string]int64{}
+
+	reporter := progress.New(LogFormat, os.Stdout)
+
+	if Operation != "upload" {
+		container = Src
+		var err error
+		objects, err = p.ListObjects(ctx, container)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var totalBytes int64
+		for _, object := range objects {
+			etags[object.Name] = object.ETag
+			sizes[object.Name] = object.Size
+			totalBytes += object.Size
+		}
+		reporter.SetTotals(len(objects), totalBytes)
 	}
-	c.Auth()
 
 	for i := 0; i < Concurrency; i++ {
 		wg.Add(1)
 		if Operation == "upload" {
-			go c.Upload(i, ci, wg, Src)
+			go Upload(ctx, p, Dest, i, ci, wg, Src, SegmentSize, limiter, cnt, reporter)
 		} else if Operation == "download" {
 			Dest, _ = filepath.Abs(Dest)
-			go c.Download(i, ci, wg, Dest)
+			go Download(ctx, p, Src, i, ci, wg, Dest, limiter, cnt, etags, sizes, reporter)
 		} else {
-			go c.Delete(i, ci, wg)
+			go Delete(ctx, p, Src, i, ci, wg, cnt, reporter)
 		}
 	}
 
 	if Operation == "upload" {
-		c.Container = Dest
-		c.CreateContainer()
+		container = Dest
+		if err := p.CreateContainer(ctx, container); err != nil {
+			log.Fatal(err)
+		}
 		w = Walker{ci: ci}
 		filepath.Walk(Src, w.Walk)
 		close(w.ci)
 	} else {
-		c.Container = Src
-		c.ListObjects(ci)
+		go func() {
+			for _, object := range objects {
+				ci <- object.Name
+			}
+			close(ci)
+		}()
 	}
 
 	wg.Wait()
+	reporter.Close()
+
+	fmt.Printf("\n%d succeeded, %d failed, %d retried\n", cnt.successes, cnt.failures, retry.Retries())
+	if cnt.failures > 0 {
+		os.Exit(1)
+	}
 }
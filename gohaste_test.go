@@ -0,0 +1,39 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import "testing"
+
+func TestLooksLikeMD5(t *testing.T) {
+	cases := []struct {
+		etag string
+		want bool
+	}{
+		{"d41d8cd98f00b204e9800998ecf8427e", true},
+		{"D41D8CD98F00B204E9800998ECF8427E", true},
+		{"", false},
+		{"d41d8cd98f00b204e9800998ecf8427", false},     // 31 chars
+		{"d41d8cd98f00b204e9800998ecf8427ee", false},   // 33 chars
+		{"d41d8cd98f00b204e9800998ecf8427g", false},    // non-hex last char, right length
+		{"\"d41d8cd98f00b204e9800998ecf8427\"", false}, // quoted, as some backends send it
+		{"0123456789abcdef0123456789abcdez", false},    // non-hex char, right length
+	}
+	for _, c := range cases {
+		if got := looksLikeMD5(c.etag); got != c.want {
+			t.Errorf("looksLikeMD5(%q) = %v, want %v", c.etag, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package progress reports per-file and aggregate transfer progress for
+// gohaste's upload/download/delete/sync operations, as either a live TTY
+// display or a newline-delimited JSON event stream that other tools can
+// consume.
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventType identifies the lifecycle stage an Event reports.
+type EventType string
+
+const (
+	// EventStart is emitted when a thread begins work on path.
+	EventStart EventType = "start"
+	// EventProgress is emitted as bytes of path are transferred.
+	EventProgress EventType = "progress"
+	// EventDone is emitted when path finishes successfully.
+	EventDone EventType = "done"
+	// EventError is emitted when path fails.
+	EventError EventType = "error"
+)
+
+// Event is one line of the `-log-format=json` event stream.
+type Event struct {
+	Type   EventType `json:"type"`
+	Path   string    `json:"path"`
+	Bytes  int64     `json:"bytes"`
+	Total  int64     `json:"total"`
+	Thread int       `json:"thread"`
+	TS     time.Time `json:"ts"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Reporter is told about every file a worker thread starts, makes
+// progress on, finishes, or fails, plus how many files and bytes the
+// whole run expects to move in total.
+type Reporter interface {
+	// SetTotals records the overall size of the run, if known up front.
+	// objects or bytes may be 0 if that total isn't known in advance
+	// (e.g. an upload whose source is still being walked).
+	SetTotals(objects int, bytes int64)
+
+	// Start reports that thread has begun transferring path, which is
+	// total bytes long (0 if unknown).
+	Start(thread int, path string, total int64)
+
+	// Advance reports that n more bytes of path have been transferred
+	// by thread since the last Advance or Start call.
+	Advance(thread int, path string, n int64)
+
+	// Done reports that thread finished path successfully.
+	Done(thread int, path string)
+
+	// Error reports that thread failed path with err.
+	Error(thread int, path string, err error)
+
+	// Close flushes and stops the reporter. It must be called exactly
+	// once, after every worker thread has finished.
+	Close()
+}
+
+// New returns a Reporter appropriate for w: a JSON event stream if
+// format is "json", a live multi-line display if w is a terminal, or a
+// plain line-at-a-time log otherwise (e.g. when output is redirected to
+// a file or piped to another program).
+func New(format string, w *os.File) Reporter {
+	if format == "json" {
+		return &jsonReporter{w: w}
+	}
+	if isTerminal(w) {
+		return newTTYReporter(w)
+	}
+	return &textReporter{w: w}
+}
+
+// jsonReporter writes each Event as a newline-delimited JSON object.
+type jsonReporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (r *jsonReporter) emit(e Event) {
+	e.TS = time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	enc := json.NewEncoder(r.w)
+	enc.Encode(e)
+}
+
+func (r *jsonReporter) SetTotals(objects int, bytes int64) {}
+
+func (r *jsonReporter) Start(thread int, path string, total int64) {
+	r.emit(Event{Type: EventStart, Path: path, Total: total, Thread: thread})
+}
+
+func (r *jsonReporter) Advance(thread int, path string, n int64) {
+	r.emit(Event{Type: EventProgress, Path: path, Bytes: n, Thread: thread})
+}
+
+func (r *jsonReporter) Done(thread int, path string) {
+	r.emit(Event{Type: EventDone, Path: path, Thread: thread})
+}
+
+func (r *jsonReporter) Error(thread int, path string, err error) {
+	r.emit(Event{Type: EventError, Path: path, Thread: thread, Error: err.Error()})
+}
+
+func (r *jsonReporter) Close() {}
+
+// textReporter is the traditional `fmt.Printf("Thread %03d: ...")` log,
+// used when output isn't a terminal and JSON wasn't requested.
+type textReporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (r *textReporter) printf(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(r.w, format, args...)
+}
+
+func (r *textReporter) SetTotals(objects int, bytes int64) {}
+
+func (r *textReporter) Start(thread int, path string, total int64) {
+	r.printf("Thread %03d: starting %s\n", thread, path)
+}
+
+func (r *textReporter) Advance(thread int, path string, n int64) {}
+
+func (r *textReporter) Done(thread int, path string) {
+	r.printf("Thread %03d: complete for %s\n", thread, path)
+}
+
+func (r *textReporter) Error(thread int, path string, err error) {
+	r.printf("Thread %03d: %s: %s\n", thread, path, err)
+}
+
+func (r *textReporter) Close() {}
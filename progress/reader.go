@@ -0,0 +1,44 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package progress
+
+import "io"
+
+// countingReader wraps an io.Reader, reporting every chunk read through
+// it to a Reporter as Advance events for path on thread.
+type countingReader struct {
+	r        io.Reader
+	reporter Reporter
+	thread   int
+	path     string
+}
+
+// Reader returns r wrapped so every chunk read through it is reported to
+// reporter as progress for path on thread.
+func Reader(r io.Reader, reporter Reporter, thread int, path string) io.Reader {
+	if reporter == nil {
+		return r
+	}
+	return &countingReader{r: r, reporter: reporter, thread: thread, path: path}
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		cr.reporter.Advance(cr.thread, cr.path, int64(n))
+	}
+	return n, err
+}
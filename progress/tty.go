@@ -0,0 +1,218 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package progress
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// repaintInterval is how often the TTY display redraws.
+const repaintInterval = 200 * time.Millisecond
+
+// isTerminal reports whether w looks like an interactive terminal,
+// rather than a redirected file or pipe.
+func isTerminal(w *os.File) bool {
+	info, err := w.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// threadState is the in-flight file a worker thread is transferring.
+type threadState struct {
+	path  string
+	done  int64
+	total int64
+}
+
+// ttyReporter renders a live, multi-line display: one line per active
+// thread showing its current file and progress, followed by an
+// aggregate line with total objects, bytes, throughput, and ETA. It
+// repaints in place using ANSI cursor-movement escapes.
+type ttyReporter struct {
+	w *os.File
+
+	mu       sync.Mutex
+	threads  map[int]*threadState
+	lastRows int
+
+	start time.Time
+
+	totalObjects int64
+	doneObjects  int64
+	totalBytes   int64
+	doneBytes    int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newTTYReporter(w *os.File) *ttyReporter {
+	r := &ttyReporter{
+		w:       w,
+		threads: map[int]*threadState{},
+		start:   time.Now(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+func (r *ttyReporter) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(repaintInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.paint()
+		case <-r.stop:
+			r.paint()
+			return
+		}
+	}
+}
+
+func (r *ttyReporter) SetTotals(objects int, bytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.totalObjects = int64(objects)
+	r.totalBytes = bytes
+}
+
+func (r *ttyReporter) Start(thread int, path string, total int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.threads[thread] = &threadState{path: path, total: total}
+}
+
+func (r *ttyReporter) Advance(thread int, path string, n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.threads[thread]; ok {
+		t.done += n
+	}
+	r.doneBytes += n
+}
+
+func (r *ttyReporter) Done(thread int, path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.threads, thread)
+	r.doneObjects++
+}
+
+func (r *ttyReporter) Error(thread int, path string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.threads, thread)
+	fmt.Fprintf(r.w, "\nThread %03d: %s: %s\n", thread, path, err)
+}
+
+func (r *ttyReporter) Close() {
+	close(r.stop)
+	<-r.done
+}
+
+// paint redraws the display in place: it moves the cursor up over the
+// previous frame, then writes the new one.
+func (r *ttyReporter) paint() {
+	r.mu.Lock()
+	threadNums := make([]int, 0, len(r.threads))
+	for n := range r.threads {
+		threadNums = append(threadNums, n)
+	}
+	sort.Ints(threadNums)
+
+	lines := make([]string, 0, len(threadNums)+1)
+	for _, n := range threadNums {
+		t := r.threads[n]
+		lines = append(lines, fmt.Sprintf("  thread %03d: %s %s", n, t.path, barFor(t.done, t.total)))
+	}
+	lines = append(lines, r.summaryLine())
+	r.mu.Unlock()
+
+	if r.lastRows > 0 {
+		fmt.Fprintf(r.w, "\033[%dA", r.lastRows)
+	}
+	for _, line := range lines {
+		fmt.Fprint(r.w, "\033[2K\r", line, "\n")
+	}
+	r.lastRows = len(lines)
+}
+
+// summaryLine renders the aggregate objects/bytes/throughput/ETA line.
+func (r *ttyReporter) summaryLine() string {
+	elapsed := time.Since(r.start).Seconds()
+	var mbps float64
+	if elapsed > 0 {
+		mbps = float64(r.doneBytes) / elapsed / (1024 * 1024)
+	}
+
+	objects := fmt.Sprintf("%d", r.doneObjects)
+	if r.totalObjects > 0 {
+		objects = fmt.Sprintf("%d/%d", r.doneObjects, r.totalObjects)
+	}
+
+	eta := "unknown"
+	if r.totalBytes > 0 && r.doneBytes > 0 && mbps > 0 {
+		remaining := float64(r.totalBytes-r.doneBytes) / (1024 * 1024) / mbps
+		if remaining > 0 {
+			eta = time.Duration(remaining * float64(time.Second)).Round(time.Second).String()
+		} else {
+			eta = "0s"
+		}
+	}
+
+	return fmt.Sprintf("objects: %s  bytes: %s  %.2f MB/s  eta: %s",
+		objects, humanBytes(r.doneBytes), mbps, eta)
+}
+
+// barFor renders a simple percentage for a single file's progress, or
+// just the bytes done if its total size isn't known.
+func barFor(done, total int64) string {
+	if total <= 0 {
+		return humanBytes(done)
+	}
+	pct := float64(done) / float64(total) * 100
+	const width = 20
+	filled := int(pct / 100 * width)
+	if filled > width {
+		filled = width
+	}
+	return fmt.Sprintf("[%s%s] %5.1f%%", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), pct)
+}
+
+// humanBytes formats n bytes as a short human-readable size.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
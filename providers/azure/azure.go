@@ -0,0 +1,316 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package azure implements providers.StorageProvider against Azure Blob
+// Storage, signing requests with a Shared Key per the Azure Storage REST
+// API's canonicalized-headers scheme.
+package azure
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sivel/gohaste/providers"
+	"github.com/sivel/gohaste/providers/retry"
+)
+
+// Blob is a providers.StorageProvider backed by Azure Blob Storage.
+type Blob struct {
+	Account string
+	Key     string
+
+	key    []byte
+	client *http.Client
+}
+
+// New returns an Azure Blob provider for the given storage account and
+// base64-encoded account key.
+func New(account, key string) *Blob {
+	return &Blob{
+		Account: account,
+		Key:     key,
+		client:  &http.Client{},
+	}
+}
+
+// Auth decodes the account key. Azure Shared Key auth is stateless, so
+// there is no session to establish.
+func (b *Blob) Auth(ctx context.Context) error {
+	key, err := base64.StdEncoding.DecodeString(b.Key)
+	if err != nil {
+		return fmt.Errorf("invalid Azure account key: %s", err)
+	}
+	b.key = key
+	return nil
+}
+
+// do sends a signed request, retrying transient failures with backoff.
+// Shared Key auth has no session to reauth, so a 401/403 is permanent.
+func (b *Blob) do(ctx context.Context, req *http.Request, contentLength int64) (*http.Response, error) {
+	var res *http.Response
+	_, err := retry.Do(ctx, nil, func(attempt int) (time.Duration, error) {
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return 0, err
+			}
+			req.Body = body
+		}
+		b.sign(req, contentLength)
+
+		var err error
+		res, err = b.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return 0, retry.Retryable(err)
+		}
+		if retry.RetryableStatus(res.StatusCode) {
+			retryAfter := retry.RetryAfter(res.Header)
+			res.Body.Close()
+			return retryAfter, retry.Retryable(fmt.Errorf("status %d", res.StatusCode))
+		}
+		return 0, nil
+	})
+	return res, err
+}
+
+func (b *Blob) sign(req *http.Request, contentLength int64) {
+	date := time.Now().UTC().Format(http.TimeFormat)
+	req.Header.Set("x-ms-date", date)
+	req.Header.Set("x-ms-version", "2020-04-08")
+
+	var msHeaders []string
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-ms-") {
+			msHeaders = append(msHeaders, lower)
+		}
+	}
+	sortStrings(msHeaders)
+	var canonicalizedHeaders strings.Builder
+	for _, name := range msHeaders {
+		canonicalizedHeaders.WriteString(fmt.Sprintf("%s:%s\n", name, req.Header.Get(name)))
+	}
+
+	cl := ""
+	if contentLength > 0 {
+		cl = fmt.Sprintf("%d", contentLength)
+	}
+
+	canonicalizedResource := canonicalizeResource(b.Account, req.URL)
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		"",  // Content-Encoding
+		"",  // Content-Language
+		cl,  // Content-Length
+		"",  // Content-MD5
+		"",  // Content-Type
+		"",  // Date
+		"",  // If-Modified-Since
+		"",  // If-Match
+		"",  // If-None-Match
+		"",  // If-Unmodified-Since
+		"",  // Range
+		canonicalizedHeaders.String() + canonicalizedResource,
+	}, "\n")
+
+	h := hmac.New(sha256.New, b.key)
+	h.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", b.Account, signature))
+}
+
+// canonicalizeResource builds the CanonicalizedResource string Shared Key
+// auth signs: /account/path, followed by the blob/container's query
+// parameters, lowercased and sorted by name, one "name:value" per line.
+func canonicalizeResource(account string, u *url.URL) string {
+	resource := fmt.Sprintf("/%s%s", account, u.Path)
+
+	query := u.Query()
+	if len(query) == 0 {
+		return resource
+	}
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sortStrings(keys)
+
+	var b strings.Builder
+	b.WriteString(resource)
+	for _, k := range keys {
+		values := query[k]
+		sortStrings(values)
+		b.WriteString(fmt.Sprintf("\n%s:%s", strings.ToLower(k), strings.Join(values, ",")))
+	}
+	return b.String()
+}
+
+func sortStrings(s []string) {
+	for i := 1; i < len(s); i++ {
+		for j := i; j > 0 && s[j] < s[j-1]; j-- {
+			s[j], s[j-1] = s[j-1], s[j]
+		}
+	}
+}
+
+func (b *Blob) endpoint(container, name string) string {
+	u := fmt.Sprintf("https://%s.blob.core.windows.net/%s", b.Account, container)
+	if len(name) > 0 {
+		u = fmt.Sprintf("%s/%s", u, name)
+	}
+	return u
+}
+
+type enumerationResults struct {
+	Blobs struct {
+		Blob []struct {
+			Name       string `xml:"Name"`
+			Properties struct {
+				ContentLength int64  `xml:"Content-Length"`
+				ETag          string `xml:"Etag"`
+			} `xml:"Properties"`
+		} `xml:"Blob"`
+	} `xml:"Blobs"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+// ListObjects returns every blob in container.
+func (b *Blob) ListObjects(ctx context.Context, container string) ([]providers.Object, error) {
+	var objects []providers.Object
+	var marker string
+
+	for {
+		reqURL := fmt.Sprintf("%s?restype=container&comp=list", b.endpoint(container, ""))
+		if len(marker) > 0 {
+			reqURL = fmt.Sprintf("%s&marker=%s", reqURL, marker)
+		}
+		req, _ := http.NewRequest("GET", reqURL, nil)
+
+		res, err := b.do(ctx, req, 0)
+		if err != nil {
+			return nil, err
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != 200 {
+			return nil, fmt.Errorf("list blobs failed: status %d", res.StatusCode)
+		}
+
+		var result enumerationResults
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+		for _, blob := range result.Blobs.Blob {
+			objects = append(objects, providers.Object{
+				Name: blob.Name,
+				Size: blob.Properties.ContentLength,
+				ETag: strings.Trim(blob.Properties.ETag, `"`),
+			})
+		}
+		if len(result.NextMarker) == 0 {
+			break
+		}
+		marker = result.NextMarker
+	}
+
+	return objects, nil
+}
+
+// PutObject uploads a block blob named name in container, calling
+// getBody once and buffering the body in memory; every attempt resends
+// that buffer.
+func (b *Blob) PutObject(ctx context.Context, container, name string, getBody func() (io.ReadCloser, error)) error {
+	body, err := getBody()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+
+	req, _ := http.NewRequest("PUT", b.endpoint(container, name), strings.NewReader(string(data)))
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	req.ContentLength = int64(len(data))
+
+	res, err := b.do(ctx, req, req.ContentLength)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 201 {
+		return fmt.Errorf("put blob failed: status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// GetObject returns a reader for the blob named name in container.
+func (b *Blob) GetObject(ctx context.Context, container, name string) (io.ReadCloser, error) {
+	req, _ := http.NewRequest("GET", b.endpoint(container, name), nil)
+
+	res, err := b.do(ctx, req, 0)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, fmt.Errorf("get blob failed: status %d", res.StatusCode)
+	}
+	return res.Body, nil
+}
+
+// DeleteObject removes the blob named name from container.
+func (b *Blob) DeleteObject(ctx context.Context, container, name string) error {
+	req, _ := http.NewRequest("DELETE", b.endpoint(container, name), nil)
+
+	res, err := b.do(ctx, req, 0)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 202 {
+		return fmt.Errorf("delete blob failed: status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// CreateContainer ensures that container exists.
+func (b *Blob) CreateContainer(ctx context.Context, container string) error {
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("%s?restype=container", b.endpoint(container, "")), nil)
+
+	res, err := b.do(ctx, req, 0)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 201 && res.StatusCode != 409 {
+		return fmt.Errorf("create container failed: status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package b2 implements providers.StorageProvider against Backblaze B2,
+// using the b2_authorize_account/b2_get_upload_url application flow
+// described at https://www.backblaze.com/b2/docs/.
+package b2
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sivel/gohaste/providers"
+	"github.com/sivel/gohaste/providers/retry"
+)
+
+const authURL = "https://api.backblazeb2.com/b2api/v2/b2_authorize_account"
+
+// B2 is a providers.StorageProvider backed by Backblaze B2.
+type B2 struct {
+	KeyId  string
+	AppKey string
+
+	apiURL             string
+	downloadURL        string
+	authorizationToken string
+	accountId          string
+	bucketIds          map[string]string
+	client             *http.Client
+}
+
+// New returns a B2 provider for the given application key ID and key.
+func New(keyId, appKey string) *B2 {
+	return &B2{
+		KeyId:     keyId,
+		AppKey:    appKey,
+		bucketIds: make(map[string]string),
+		client:    &http.Client{},
+	}
+}
+
+type authorizeResponse struct {
+	AuthorizationToken string `json:"authorizationToken"`
+	ApiUrl             string `json:"apiUrl"`
+	DownloadUrl        string `json:"downloadUrl"`
+	AccountId          string `json:"accountId"`
+}
+
+// Auth exchanges the application key for a session authorization token.
+func (b *B2) Auth(ctx context.Context) error {
+	req, _ := http.NewRequest("GET", authURL, nil)
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(b.KeyId+":"+b.AppKey)))
+
+	res, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != 200 {
+		return fmt.Errorf("b2_authorize_account failed: status %d: %s", res.StatusCode, string(body))
+	}
+
+	var auth authorizeResponse
+	if err := json.Unmarshal(body, &auth); err != nil {
+		return err
+	}
+	b.authorizationToken = auth.AuthorizationToken
+	b.apiURL = auth.ApiUrl
+	b.downloadURL = auth.DownloadUrl
+	b.accountId = auth.AccountId
+	return nil
+}
+
+// call invokes a b2api endpoint, retrying transient failures with backoff
+// and refreshing the session authorization token on a 401.
+func (b *B2) call(ctx context.Context, path string, payload interface{}, out interface{}) error {
+	body, _ := json.Marshal(payload)
+
+	var resBody []byte
+	_, err := retry.Do(ctx, func() error { return b.Auth(ctx) }, func(attempt int) (time.Duration, error) {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("%s/b2api/v2/%s", b.apiURL, path), strings.NewReader(string(body)))
+		req.Header.Set("Authorization", b.authorizationToken)
+		req.Header.Set("Content-Type", "application/json")
+
+		res, err := b.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return 0, retry.Retryable(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode == 401 {
+			return 0, retry.ErrUnauthorized
+		}
+		if retry.RetryableStatus(res.StatusCode) {
+			retryAfter := retry.RetryAfter(res.Header)
+			return retryAfter, retry.Retryable(fmt.Errorf("%s failed: status %d", path, res.StatusCode))
+		}
+		resBody, err = ioutil.ReadAll(res.Body)
+		if err != nil {
+			return 0, err
+		}
+		if res.StatusCode != 200 {
+			return 0, fmt.Errorf("%s failed: status %d: %s", path, res.StatusCode, string(resBody))
+		}
+		return 0, nil
+	})
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		return json.Unmarshal(resBody, out)
+	}
+	return nil
+}
+
+// bucketId resolves bucketName to its bucketId, using b2_list_buckets and
+// caching the result since a gohaste run only ever talks to one bucket.
+func (b *B2) bucketId(ctx context.Context, bucketName string) (string, error) {
+	if id, ok := b.bucketIds[bucketName]; ok {
+		return id, nil
+	}
+
+	var out struct {
+		Buckets []struct {
+			BucketId   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+		} `json:"buckets"`
+	}
+	if err := b.call(ctx, "b2_list_buckets", map[string]string{"accountId": b.accountId}, &out); err != nil {
+		return "", err
+	}
+	for _, bucket := range out.Buckets {
+		b.bucketIds[bucket.BucketName] = bucket.BucketId
+		if bucket.BucketName == bucketName {
+			return bucket.BucketId, nil
+		}
+	}
+	return "", fmt.Errorf("bucket %s not found", bucketName)
+}
+
+// ListObjects returns every file in bucketName.
+func (b *B2) ListObjects(ctx context.Context, bucketName string) ([]providers.Object, error) {
+	bucketId, err := b.bucketId(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []providers.Object
+	startFileName := ""
+
+	for {
+		payload := map[string]interface{}{"bucketId": bucketId}
+		if len(startFileName) > 0 {
+			payload["startFileName"] = startFileName
+		}
+		var out struct {
+			Files []struct {
+				FileName    string `json:"fileName"`
+				Size        int64  `json:"contentLength"`
+				ContentSha1 string `json:"contentSha1"`
+			} `json:"files"`
+			NextFileName string `json:"nextFileName"`
+		}
+		if err := b.call(ctx, "b2_list_file_names", payload, &out); err != nil {
+			return nil, err
+		}
+		for _, f := range out.Files {
+			objects = append(objects, providers.Object{Name: f.FileName, Size: f.Size, ETag: f.ContentSha1})
+		}
+		if len(out.NextFileName) == 0 {
+			break
+		}
+		startFileName = out.NextFileName
+	}
+
+	return objects, nil
+}
+
+// PutObject uploads fileName in bucketName, calling getBody for the
+// request body. A fresh upload URL is requested on every attempt, since
+// a B2 upload URL can become invalid if the connection it's paired with
+// fails.
+func (b *B2) PutObject(ctx context.Context, bucketName, fileName string, getBody func() (io.ReadCloser, error)) error {
+	bucketId, err := b.bucketId(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+
+	body, err := getBody()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+
+	_, err = retry.Do(ctx, func() error { return b.Auth(ctx) }, func(attempt int) (time.Duration, error) {
+		var uploadURLResp struct {
+			UploadUrl          string `json:"uploadUrl"`
+			AuthorizationToken string `json:"authorizationToken"`
+		}
+		if err := b.call(ctx, "b2_get_upload_url", map[string]string{"bucketId": bucketId}, &uploadURLResp); err != nil {
+			return 0, err
+		}
+
+		req, _ := http.NewRequest("POST", uploadURLResp.UploadUrl, strings.NewReader(string(data)))
+		req.Header.Set("Authorization", uploadURLResp.AuthorizationToken)
+		req.Header.Set("X-Bz-File-Name", fileName)
+		req.Header.Set("Content-Type", "b2/x-auto")
+		req.Header.Set("X-Bz-Content-Sha1", "do_not_verify")
+		req.ContentLength = int64(len(data))
+
+		res, err := b.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return 0, retry.Retryable(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode == 401 {
+			return 0, retry.ErrUnauthorized
+		}
+		if retry.RetryableStatus(res.StatusCode) {
+			retryAfter := retry.RetryAfter(res.Header)
+			return retryAfter, retry.Retryable(fmt.Errorf("b2_upload_file failed: status %d", res.StatusCode))
+		}
+		if res.StatusCode != 200 {
+			return 0, fmt.Errorf("b2_upload_file failed: status %d", res.StatusCode)
+		}
+		return 0, nil
+	})
+	return err
+}
+
+// GetObject returns a reader for fileName in bucketName.
+func (b *B2) GetObject(ctx context.Context, bucketName, fileName string) (io.ReadCloser, error) {
+	var res *http.Response
+	_, err := retry.Do(ctx, func() error { return b.Auth(ctx) }, func(attempt int) (time.Duration, error) {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/file/%s/%s", b.downloadURL, bucketName, fileName), nil)
+		req.Header.Set("Authorization", b.authorizationToken)
+
+		var err error
+		res, err = b.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return 0, retry.Retryable(err)
+		}
+		if res.StatusCode == 401 {
+			res.Body.Close()
+			return 0, retry.ErrUnauthorized
+		}
+		if retry.RetryableStatus(res.StatusCode) {
+			retryAfter := retry.RetryAfter(res.Header)
+			res.Body.Close()
+			return retryAfter, retry.Retryable(fmt.Errorf("b2_download_file_by_name failed: status %d", res.StatusCode))
+		}
+		return 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, fmt.Errorf("b2_download_file_by_name failed: status %d", res.StatusCode)
+	}
+	return res.Body, nil
+}
+
+// DeleteObject removes every version of fileName from bucketName.
+func (b *B2) DeleteObject(ctx context.Context, bucketName, fileName string) error {
+	bucketId, err := b.bucketId(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"bucketId":      bucketId,
+		"startFileName": fileName,
+	}
+	for {
+		var listResp struct {
+			Files []struct {
+				FileId   string `json:"fileId"`
+				FileName string `json:"fileName"`
+			} `json:"files"`
+			NextFileName string `json:"nextFileName"`
+			NextFileId   string `json:"nextFileId"`
+		}
+		if err := b.call(ctx, "b2_list_file_versions", payload, &listResp); err != nil {
+			return err
+		}
+		for _, f := range listResp.Files {
+			if f.FileName != fileName {
+				continue
+			}
+			if err := b.call(ctx, "b2_delete_file_version", map[string]string{
+				"fileName": f.FileName,
+				"fileId":   f.FileId,
+			}, nil); err != nil {
+				return err
+			}
+		}
+		if listResp.NextFileName != fileName {
+			return nil
+		}
+		payload["startFileId"] = listResp.NextFileId
+	}
+}
+
+// CreateContainer ensures that the bucket named bucketName exists.
+func (b *B2) CreateContainer(ctx context.Context, bucketName string) error {
+	if _, err := b.bucketId(ctx, bucketName); err == nil {
+		return nil
+	}
+	var out struct {
+		BucketId string `json:"bucketId"`
+	}
+	if err := b.call(ctx, "b2_create_bucket", map[string]string{
+		"accountId":  b.accountId,
+		"bucketName": bucketName,
+		"bucketType": "allPrivate",
+	}, &out); err != nil {
+		return err
+	}
+	b.bucketIds[bucketName] = out.BucketId
+	return nil
+}
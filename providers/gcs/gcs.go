@@ -0,0 +1,300 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package gcs implements providers.StorageProvider against Google Cloud
+// Storage, authenticating with a service account JSON key via a
+// self-signed JWT bearer assertion.
+package gcs
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sivel/gohaste/providers"
+	"github.com/sivel/gohaste/providers/retry"
+)
+
+const (
+	tokenURL = "https://oauth2.googleapis.com/token"
+	apiURL   = "https://storage.googleapis.com/storage/v1"
+	scope    = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// GCS is a providers.StorageProvider backed by Google Cloud Storage.
+type GCS struct {
+	KeyFile string
+
+	key         serviceAccountKey
+	privateKey  *rsa.PrivateKey
+	accessToken string
+	client      *http.Client
+}
+
+// New returns a GCS provider that authenticates using the service account
+// key at keyFile.
+func New(keyFile string) *GCS {
+	return &GCS{
+		KeyFile: keyFile,
+		client:  &http.Client{},
+	}
+}
+
+func base64URLEncode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Auth loads the service account key and exchanges a self-signed JWT for
+// an OAuth2 access token.
+func (g *GCS) Auth(ctx context.Context) error {
+	raw, err := ioutil.ReadFile(g.KeyFile)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, &g.key); err != nil {
+		return err
+	}
+
+	block, _ := pem.Decode([]byte(g.key.PrivateKey))
+	if block == nil {
+		return fmt.Errorf("unable to decode private key in %s", g.KeyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("private key in %s is not RSA", g.KeyFile)
+	}
+	g.privateKey = privateKey
+
+	now := time.Now()
+	header := base64URLEncode([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   g.key.ClientEmail,
+		"scope": scope,
+		"aud":   tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	payload := base64URLEncode(claims)
+	signingInput := header + "." + payload
+
+	hashed := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, g.privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+	assertion := signingInput + "." + base64URLEncode(signature)
+
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+
+	req, _ := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := g.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != 200 {
+		return fmt.Errorf("token exchange failed: status %d: %s", res.StatusCode, string(body))
+	}
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return err
+	}
+	g.accessToken = tok.AccessToken
+	return nil
+}
+
+// request sends a GCS API call, retrying transient failures with
+// backoff and refreshing the access token on a 401.
+func (g *GCS) request(ctx context.Context, method, reqURL string, getBody func() (io.Reader, error), contentType string) (*http.Response, error) {
+	var res *http.Response
+	_, err := retry.Do(ctx, func() error { return g.Auth(ctx) }, func(attempt int) (time.Duration, error) {
+		var body io.Reader
+		if getBody != nil {
+			var err error
+			body, err = getBody()
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		if closer, ok := body.(io.Closer); ok {
+			defer closer.Close()
+		}
+
+		req, err := http.NewRequest(method, reqURL, body)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Authorization", "Bearer "+g.accessToken)
+		if len(contentType) > 0 {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		res, err = g.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return 0, retry.Retryable(err)
+		}
+		if res.StatusCode == 401 {
+			res.Body.Close()
+			return 0, retry.ErrUnauthorized
+		}
+		if retry.RetryableStatus(res.StatusCode) {
+			retryAfter := retry.RetryAfter(res.Header)
+			res.Body.Close()
+			return retryAfter, retry.Retryable(fmt.Errorf("status %d", res.StatusCode))
+		}
+		return 0, nil
+	})
+	return res, err
+}
+
+type objectListResponse struct {
+	Items []struct {
+		Name string `json:"name"`
+		Size string `json:"size"`
+		ETag string `json:"etag"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// ListObjects returns every object in bucket.
+func (g *GCS) ListObjects(ctx context.Context, bucket string) ([]providers.Object, error) {
+	var objects []providers.Object
+	var pageToken string
+
+	for {
+		reqURL := fmt.Sprintf("%s/b/%s/o", apiURL, bucket)
+		if len(pageToken) > 0 {
+			reqURL = fmt.Sprintf("%s?pageToken=%s", reqURL, pageToken)
+		}
+		res, err := g.request(ctx, "GET", reqURL, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != 200 {
+			return nil, fmt.Errorf("list objects failed: status %d", res.StatusCode)
+		}
+
+		var list objectListResponse
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			size, _ := strconv.ParseInt(item.Size, 10, 64)
+			objects = append(objects, providers.Object{Name: item.Name, Size: size, ETag: item.ETag})
+		}
+		if len(list.NextPageToken) == 0 {
+			break
+		}
+		pageToken = list.NextPageToken
+	}
+
+	return objects, nil
+}
+
+// PutObject uploads name in bucket using the simple (media) upload
+// endpoint, calling getBody for the request body on every attempt.
+func (g *GCS) PutObject(ctx context.Context, bucket, name string, getBody func() (io.ReadCloser, error)) error {
+	reqURL := fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+		bucket, url.QueryEscape(name))
+	res, err := g.request(ctx, "POST", reqURL, func() (io.Reader, error) { return getBody() }, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("put object failed: status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// GetObject returns a reader for name in bucket.
+func (g *GCS) GetObject(ctx context.Context, bucket, name string) (io.ReadCloser, error) {
+	reqURL := fmt.Sprintf("%s/b/%s/o/%s?alt=media", apiURL, bucket, url.QueryEscape(name))
+	res, err := g.request(ctx, "GET", reqURL, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, fmt.Errorf("get object failed: status %d", res.StatusCode)
+	}
+	return res.Body, nil
+}
+
+// DeleteObject removes name from bucket.
+func (g *GCS) DeleteObject(ctx context.Context, bucket, name string) error {
+	reqURL := fmt.Sprintf("%s/b/%s/o/%s", apiURL, bucket, url.QueryEscape(name))
+	res, err := g.request(ctx, "DELETE", reqURL, nil, "")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 204 && res.StatusCode != 200 {
+		return fmt.Errorf("delete object failed: status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// CreateContainer ensures that bucket exists.
+func (g *GCS) CreateContainer(ctx context.Context, bucket string) error {
+	projectID := g.key.ClientEmail[strings.Index(g.key.ClientEmail, "@")+1:]
+	projectID = strings.TrimSuffix(projectID, ".iam.gserviceaccount.com")
+
+	payload, _ := json.Marshal(map[string]string{"name": bucket})
+	reqURL := fmt.Sprintf("%s/b?project=%s", apiURL, projectID)
+	res, err := g.request(ctx, "POST", reqURL, func() (io.Reader, error) { return strings.NewReader(string(payload)), nil }, "application/json")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 && res.StatusCode != 409 {
+		return fmt.Errorf("create bucket failed: status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package oss implements providers.StorageProvider against Alibaba Cloud
+// Object Storage Service, signing requests the same way aliyungo does:
+// HMAC-SHA1 over a canonicalized resource and a handful of headers.
+package oss
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sivel/gohaste/providers"
+	"github.com/sivel/gohaste/providers/retry"
+)
+
+// OSS is a providers.StorageProvider backed by Alibaba Cloud OSS.
+type OSS struct {
+	AccessKeyId     string
+	AccessKeySecret string
+	Endpoint        string // e.g. oss-cn-hangzhou.aliyuncs.com
+
+	client *http.Client
+}
+
+// New returns an OSS provider for the given credentials and regional
+// endpoint.
+func New(accessKeyId, accessKeySecret, endpoint string) *OSS {
+	return &OSS{
+		AccessKeyId:     accessKeyId,
+		AccessKeySecret: accessKeySecret,
+		Endpoint:        endpoint,
+		client:          &http.Client{},
+	}
+}
+
+// Auth is a no-op for OSS: every request carries its own HMAC-SHA1
+// signature, so there is no session to establish.
+func (o *OSS) Auth(ctx context.Context) error {
+	if len(o.AccessKeyId) == 0 || len(o.AccessKeySecret) == 0 {
+		return fmt.Errorf("OSS access key id and access key secret are required")
+	}
+	return nil
+}
+
+func (o *OSS) sign(req *http.Request, bucket, contentType string) {
+	date := time.Now().UTC().Format(http.TimeFormat)
+	req.Header.Set("Date", date)
+
+	var ossHeaders []string
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-oss-") {
+			ossHeaders = append(ossHeaders, lower)
+		}
+	}
+	sortStrings(ossHeaders)
+	var canonicalizedOSSHeaders strings.Builder
+	for _, name := range ossHeaders {
+		canonicalizedOSSHeaders.WriteString(fmt.Sprintf("%s:%s\n", name, req.Header.Get(name)))
+	}
+
+	resource := req.URL.Path
+	if len(bucket) > 0 {
+		resource = fmt.Sprintf("/%s%s", bucket, req.URL.Path)
+	}
+	resource += canonicalizedSubResource(req.URL.RawQuery)
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		"", // Content-MD5
+		contentType,
+		date,
+		canonicalizedOSSHeaders.String() + resource,
+	}, "\n")
+
+	h := hmac.New(sha1.New, []byte(o.AccessKeySecret))
+	h.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	req.Header.Set("Authorization", fmt.Sprintf("OSS %s:%s", o.AccessKeyId, signature))
+}
+
+// ossSubResources are the query parameters OSS folds into the signed
+// resource. Everything else (marker, prefix, max-keys, delimiter, …) is
+// listing/pagination syntax that must be excluded, or the signature on a
+// truncated listing's second page won't match.
+var ossSubResources = map[string]bool{
+	"acl":                          true,
+	"uploads":                      true,
+	"location":                     true,
+	"cors":                         true,
+	"logging":                      true,
+	"website":                      true,
+	"referer":                      true,
+	"lifecycle":                    true,
+	"delete":                       true,
+	"append":                       true,
+	"tagging":                      true,
+	"objectMeta":                   true,
+	"uploadId":                     true,
+	"partNumber":                   true,
+	"security-token":               true,
+	"position":                     true,
+	"response-content-type":        true,
+	"response-content-language":    true,
+	"response-expires":             true,
+	"response-cache-control":       true,
+	"response-content-disposition": true,
+	"response-content-encoding":    true,
+}
+
+// canonicalizedSubResource returns the "?key=value&..." suffix OSS signs
+// for rawQuery: only its recognized sub-resource parameters, sorted by
+// key. It returns "" when rawQuery has none.
+func canonicalizedSubResource(rawQuery string) string {
+	if len(rawQuery) == 0 {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return ""
+	}
+
+	var keys []string
+	for k := range values {
+		if ossSubResources[k] {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sortStrings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		if v := values.Get(k); len(v) > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%s", k, v))
+		} else {
+			parts = append(parts, k)
+		}
+	}
+	return "?" + strings.Join(parts, "&")
+}
+
+func sortStrings(s []string) {
+	for i := 1; i < len(s); i++ {
+		for j := i; j > 0 && s[j] < s[j-1]; j-- {
+			s[j], s[j-1] = s[j-1], s[j]
+		}
+	}
+}
+
+func (o *OSS) url(bucket, key string) string {
+	u := fmt.Sprintf("https://%s.%s", bucket, o.Endpoint)
+	if len(key) > 0 {
+		u = fmt.Sprintf("%s/%s", u, key)
+	} else {
+		u = u + "/"
+	}
+	return u
+}
+
+// do sends a signed request, retrying transient failures with backoff.
+// OSS has no session to reauth, so a 401/403 is treated as permanent.
+func (o *OSS) do(ctx context.Context, method, bucket, key, query string, body []byte, contentType string) (*http.Response, error) {
+	reqURL := o.url(bucket, key)
+	if len(query) > 0 {
+		reqURL = fmt.Sprintf("%s?%s", reqURL, query)
+	}
+
+	var res *http.Response
+	_, err := retry.Do(ctx, nil, func(attempt int) (time.Duration, error) {
+		var reader io.Reader
+		if body != nil {
+			reader = strings.NewReader(string(body))
+		}
+		req, err := http.NewRequest(method, reqURL, reader)
+		if err != nil {
+			return 0, err
+		}
+		if len(contentType) > 0 {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if body != nil {
+			req.ContentLength = int64(len(body))
+		}
+		o.sign(req, bucket, contentType)
+
+		res, err = o.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return 0, retry.Retryable(err)
+		}
+		if retry.RetryableStatus(res.StatusCode) {
+			retryAfter := retry.RetryAfter(res.Header)
+			res.Body.Close()
+			return retryAfter, retry.Retryable(fmt.Errorf("status %d", res.StatusCode))
+		}
+		return 0, nil
+	})
+	return res, err
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+		ETag string `xml:"ETag"`
+	} `xml:"Contents"`
+	IsTruncated bool   `xml:"IsTruncated"`
+	NextMarker  string `xml:"NextMarker"`
+}
+
+// ListObjects returns every object in bucket.
+func (o *OSS) ListObjects(ctx context.Context, bucket string) ([]providers.Object, error) {
+	var objects []providers.Object
+	var marker string
+
+	for {
+		query := ""
+		if len(marker) > 0 {
+			query = fmt.Sprintf("marker=%s", marker)
+		}
+		res, err := o.do(ctx, "GET", bucket, "", query, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != 200 {
+			return nil, fmt.Errorf("list objects failed: status %d", res.StatusCode)
+		}
+
+		var result listBucketResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, providers.Object{
+				Name: c.Key,
+				Size: c.Size,
+				ETag: strings.Trim(c.ETag, `"`),
+			})
+			marker = c.Key
+		}
+		if !result.IsTruncated {
+			break
+		}
+	}
+
+	return objects, nil
+}
+
+// PutObject uploads key in bucket, calling getBody for the request
+// body. OSS signs over the full payload, so the body is buffered into
+// memory once rather than streamed, and every attempt resends that
+// buffer.
+func (o *OSS) PutObject(ctx context.Context, bucket, key string, getBody func() (io.ReadCloser, error)) error {
+	body, err := getBody()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+
+	res, err := o.do(ctx, "PUT", bucket, key, "", data, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("put object failed: status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// GetObject returns a reader for key in bucket.
+func (o *OSS) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	res, err := o.do(ctx, "GET", bucket, key, "", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, fmt.Errorf("get object failed: status %d", res.StatusCode)
+	}
+	return res.Body, nil
+}
+
+// DeleteObject removes key from bucket.
+func (o *OSS) DeleteObject(ctx context.Context, bucket, key string) error {
+	res, err := o.do(ctx, "DELETE", bucket, key, "", nil, "")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 204 {
+		return fmt.Errorf("delete object failed: status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// CreateContainer ensures that bucket exists.
+func (o *OSS) CreateContainer(ctx context.Context, bucket string) error {
+	res, err := o.do(ctx, "PUT", bucket, "", "", nil, "")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 && res.StatusCode != 409 {
+		return fmt.Errorf("create bucket failed: status %d", res.StatusCode)
+	}
+	return nil
+}
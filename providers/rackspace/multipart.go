@@ -0,0 +1,219 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package rackspace
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// checkpoint records the segments of a large-object upload that have
+// already completed, so an interrupted run can resume without
+// re-uploading them.
+type checkpoint struct {
+	Path     string                   `json:"path"`
+	Size     int64                    `json:"size"`
+	Segments map[int]checkpointSegment `json:"segments"`
+}
+
+type checkpointSegment struct {
+	ETag string `json:"etag"`
+	Size int64  `json:"size"`
+}
+
+// checkpointPath returns the path of the checkpoint file for the given
+// local file, under ~/.gohaste/uploads/<hash>.json.
+func checkpointPath(path string, size int64) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", abs, size)))
+	dir := filepath.Join(home, ".gohaste", "uploads")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json"), nil
+}
+
+func loadCheckpoint(path string, size int64) (*checkpoint, string, error) {
+	cpPath, err := checkpointPath(path, size)
+	if err != nil {
+		return nil, "", err
+	}
+
+	cp := &checkpoint{Path: path, Size: size, Segments: make(map[int]checkpointSegment)}
+	raw, err := ioutil.ReadFile(cpPath)
+	if err == nil {
+		json.Unmarshal(raw, cp)
+	}
+	if cp.Segments == nil {
+		cp.Segments = make(map[int]checkpointSegment)
+	}
+	return cp, cpPath, nil
+}
+
+func (cp *checkpoint) save(cpPath string) error {
+	raw, _ := json.Marshal(cp)
+	return ioutil.WriteFile(cpPath, raw, 0644)
+}
+
+type manifestEntry struct {
+	Path      string `json:"path"`
+	ETag      string `json:"etag"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// PutLargeObject uploads the file at path as a Swift Static Large
+// Object: it is split into segmentSize chunks, each uploaded in
+// parallel to container_segments/name/<n>, then a manifest referencing
+// every segment is PUT as the object itself. Progress is checkpointed to
+// disk so an interrupted upload can resume without re-uploading
+// segments that already completed.
+func (c *CloudFiles) PutLargeObject(ctx context.Context, container, name, path string, size, segmentSize int64, concurrency int) error {
+	segmentsContainer := container + "_segments"
+	if err := c.CreateContainer(ctx, segmentsContainer); err != nil {
+		return err
+	}
+
+	numSegments := int((size + segmentSize - 1) / segmentSize)
+	cp, cpPath, err := loadCheckpoint(path, size)
+	if err != nil {
+		return err
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, concurrency)
+
+	for i := 0; i < numSegments; i++ {
+		mu.Lock()
+		_, done := cp.Segments[i]
+		mu.Unlock()
+		if done {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(index int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			offset := int64(index) * segmentSize
+			length := segmentSize
+			if offset+length > size {
+				length = size - offset
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				return
+			}
+			defer file.Close()
+
+			segmentName := fmt.Sprintf("%s/%d", name, index)
+			res, err := c.doRetry(ctx, func() (*http.Response, error) {
+				if _, err := file.Seek(offset, 0); err != nil {
+					return nil, err
+				}
+				req, _ := http.NewRequest("PUT", fmt.Sprintf("%s/%s/%s", c.endpoint, segmentsContainer, segmentName), io.LimitReader(file, length))
+				req.Header.Set("X-Auth-Token", c.token)
+				req.ContentLength = length
+				return c.client.Do(req.WithContext(ctx))
+			})
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				return
+			}
+			etag := res.Header.Get("Etag")
+			res.Body.Close()
+			if res.StatusCode != 201 {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("segment %d upload failed: status %d", index, res.StatusCode)
+				}
+				mu.Unlock()
+				return
+			}
+
+			mu.Lock()
+			cp.Segments[index] = checkpointSegment{ETag: etag, Size: length}
+			cp.save(cpPath)
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	manifest := make([]manifestEntry, numSegments)
+	for i := 0; i < numSegments; i++ {
+		seg := cp.Segments[i]
+		manifest[i] = manifestEntry{
+			Path:      fmt.Sprintf("%s/%s/%d", segmentsContainer, name, i),
+			ETag:      seg.ETag,
+			SizeBytes: seg.Size,
+		}
+	}
+	body, _ := json.Marshal(manifest)
+
+	res, err := c.doRetry(ctx, func() (*http.Response, error) {
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("%s/%s/%s?multipart-manifest=put", c.endpoint, container, name), bytes.NewReader(body))
+		req.Header.Set("X-Auth-Token", c.token)
+		req.Header.Set("Content-Type", "application/json")
+		req.ContentLength = int64(len(body))
+		return c.client.Do(req.WithContext(ctx))
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 201 {
+		return fmt.Errorf("manifest upload failed: status %d", res.StatusCode)
+	}
+
+	os.Remove(cpPath)
+	return nil
+}
@@ -0,0 +1,401 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package rackspace implements providers.StorageProvider against Rackspace
+// CloudFiles, authenticating via the OpenStack Identity (Keystone) v2 API.
+package rackspace
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sivel/gohaste/providers"
+	"github.com/sivel/gohaste/providers/retry"
+)
+
+type authContainer struct {
+	Auth auth `json:"auth"`
+}
+
+type auth struct {
+	ApiKeyCredentials *apiKeyCredentials `json:"RAX-KSKEY:apiKeyCredentials,omitempty"`
+}
+
+type apiKeyCredentials struct {
+	Username string `json:"username"`
+	ApiKey   string `json:"apiKey"`
+}
+
+type tokens struct {
+	Access access `json:"access"`
+}
+
+type access struct {
+	Token          token `json:"token"`
+	ServiceCatalog []catalogEntry
+}
+
+type catalogEntry struct {
+	Name, Type string
+	Endpoints  []entryEndpoint
+}
+
+type entryEndpoint struct {
+	Region, TenantId                    string
+	PublicURL, InternalURL              string
+	VersionId, VersionInfo, VersionList string
+}
+
+type token struct {
+	Id      string `json:"id"`
+	Expires string `json:"expires"`
+}
+
+// CloudFiles is a providers.StorageProvider backed by Rackspace CloudFiles.
+type CloudFiles struct {
+	Username string
+	ApiKey   string
+	Region   string
+
+	token    string
+	endpoint string
+	client   *http.Client
+}
+
+// New returns a CloudFiles provider for the given credentials and region.
+func New(username, apiKey, region string) *CloudFiles {
+	return &CloudFiles{
+		Username: username,
+		ApiKey:   apiKey,
+		Region:   region,
+		client:   &http.Client{},
+	}
+}
+
+// Auth authenticates to CloudFiles and locates the object-store endpoint
+// for c.Region.
+func (c *CloudFiles) Auth(ctx context.Context) error {
+	var t tokens
+
+	a := &authContainer{
+		Auth: auth{
+			ApiKeyCredentials: &apiKeyCredentials{
+				Username: c.Username,
+				ApiKey:   c.ApiKey,
+			},
+		},
+	}
+	body, _ := json.Marshal(a)
+
+	req, _ := http.NewRequest("POST", "https://identity.api.rackspacecloud.com/v2.0/tokens", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(ctx)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("unable to authenticate: status %d", res.StatusCode)
+	}
+
+	resBody, _ := ioutil.ReadAll(res.Body)
+	json.Unmarshal(resBody, &t)
+
+	for _, service := range t.Access.ServiceCatalog {
+		if service.Type == "object-store" {
+			for _, endpoint := range service.Endpoints {
+				if endpoint.Region == c.Region {
+					c.endpoint = endpoint.PublicURL
+					break
+				}
+			}
+			break
+		}
+	}
+
+	if len(c.endpoint) == 0 {
+		return fmt.Errorf("no PublicURL found for object-store in region %s", c.Region)
+	}
+
+	c.token = t.Access.Token.Id
+	return nil
+}
+
+// doRetry runs buildAndSend, which should build a fresh request, send it
+// and return the response, retrying on transient failures and
+// re-authenticating on a 401.
+func (c *CloudFiles) doRetry(ctx context.Context, buildAndSend func() (*http.Response, error)) (*http.Response, error) {
+	var res *http.Response
+	_, err := retry.Do(ctx, func() error { return c.Auth(ctx) }, func(attempt int) (time.Duration, error) {
+		var err error
+		res, err = buildAndSend()
+		if err != nil {
+			return 0, retry.Retryable(err)
+		}
+		if res.StatusCode == 401 {
+			res.Body.Close()
+			return 0, retry.ErrUnauthorized
+		}
+		if retry.RetryableStatus(res.StatusCode) {
+			retryAfter := retry.RetryAfter(res.Header)
+			res.Body.Close()
+			return retryAfter, retry.Retryable(fmt.Errorf("status %d", res.StatusCode))
+		}
+		return 0, nil
+	})
+	return res, err
+}
+
+// listEntry is one entry of a `?format=json` container listing.
+type listEntry struct {
+	Name         string `json:"name"`
+	Bytes        int64  `json:"bytes"`
+	Hash         string `json:"hash"`
+	LastModified string `json:"last_modified"`
+}
+
+// swiftLastModified is the layout Swift formats last_modified timestamps
+// with: no timezone suffix, microsecond precision, implicitly UTC.
+const swiftLastModified = "2006-01-02T15:04:05.000000"
+
+// ListObjects returns every object in container. It uses the JSON
+// listing format rather than the plain-text one so that Size, ETag, and
+// LastModified are populated for `gohaste verify`.
+func (c *CloudFiles) ListObjects(ctx context.Context, container string) ([]providers.Object, error) {
+	var objects []providers.Object
+	var marker string
+
+	for {
+		m := marker
+		reqURL := fmt.Sprintf("%s/%s?format=json", c.endpoint, container)
+		if len(m) > 0 {
+			reqURL = fmt.Sprintf("%s&marker=%s", reqURL, url.QueryEscape(m))
+		}
+
+		res, err := c.doRetry(ctx, func() (*http.Response, error) {
+			req, _ := http.NewRequest("GET", reqURL, nil)
+			req.Header.Set("X-Auth-Token", c.token)
+			return c.client.Do(req.WithContext(ctx))
+		})
+		if err != nil {
+			return nil, err
+		}
+		resBody, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != 200 {
+			return nil, fmt.Errorf("list objects failed: status %d", res.StatusCode)
+		}
+
+		var entries []listEntry
+		if err := json.Unmarshal(resBody, &entries); err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for _, e := range entries {
+			lastModified, _ := time.Parse(swiftLastModified, e.LastModified)
+			objects = append(objects, providers.Object{
+				Name:         e.Name,
+				Size:         e.Bytes,
+				ETag:         e.Hash,
+				LastModified: lastModified,
+			})
+			marker = e.Name
+		}
+	}
+
+	return objects, nil
+}
+
+// PutObject uploads name in container, reopening the body via getBody on
+// every retry. getBody's reader is typically wrapped by the caller in a
+// progress/rate-limit reader, so the body is read through it exactly
+// once per attempt: the MD5 and SHA-256 are computed inline via a
+// TeeReader as the PUT streams, rather than in a separate pre-read pass,
+// which would double-count progress and rate-limit budget. The MD5 is
+// checked against the ETag CloudFiles returns in its response (it
+// computes its own MD5 server-side, catching corruption in transit),
+// and the SHA-256 is attached afterward as X-Object-Meta-Sha256 so
+// `gohaste verify` has a second, stronger checksum to compare against
+// later.
+func (c *CloudFiles) PutObject(ctx context.Context, container, name string, getBody func() (io.ReadCloser, error)) error {
+	md5Sum := md5.New()
+	sha256Sum := sha256.New()
+
+	res, err := c.doRetry(ctx, func() (*http.Response, error) {
+		body, err := getBody()
+		if err != nil {
+			return nil, err
+		}
+		md5Sum.Reset()
+		sha256Sum.Reset()
+		tee := io.TeeReader(body, io.MultiWriter(md5Sum, sha256Sum))
+
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("%s/%s/%s", c.endpoint, container, name), ioutil.NopCloser(tee))
+		req.Header.Set("X-Auth-Token", c.token)
+		res, err := c.client.Do(req.WithContext(ctx))
+		body.Close()
+		return res, err
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 && res.StatusCode != 201 {
+		return fmt.Errorf("put object failed: status %d", res.StatusCode)
+	}
+
+	md5Hex := hex.EncodeToString(md5Sum.Sum(nil))
+	if serverETag := strings.Trim(res.Header.Get("Etag"), `"`); len(serverETag) > 0 && !strings.EqualFold(serverETag, md5Hex) {
+		return fmt.Errorf("put object failed: checksum mismatch (local %s, server %s)", md5Hex, serverETag)
+	}
+
+	sha256Hex := hex.EncodeToString(sha256Sum.Sum(nil))
+	return c.setObjectSha256(ctx, container, name, sha256Hex)
+}
+
+// setObjectSha256 attaches the X-Object-Meta-Sha256 metadata to an
+// already-uploaded object via POST, which Swift applies without
+// requiring the body to be resent.
+func (c *CloudFiles) setObjectSha256(ctx context.Context, container, name, sha256Hex string) error {
+	res, err := c.doRetry(ctx, func() (*http.Response, error) {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("%s/%s/%s", c.endpoint, container, name), nil)
+		req.Header.Set("X-Auth-Token", c.token)
+		req.Header.Set("X-Object-Meta-Sha256", sha256Hex)
+		return c.client.Do(req.WithContext(ctx))
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 202 && res.StatusCode != 200 {
+		return fmt.Errorf("set object metadata failed: status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// GetObject returns a reader for name in container. If name is a Static
+// Large Object manifest, its segments are fetched in parallel and
+// reassembled, rather than relying on the single-connection reassembly
+// the proxy server would otherwise do for us. A Dynamic Large Object is
+// read with a plain GET and reassembled by the proxy server; its
+// segments are not fetched in parallel. Both kinds of manifest body
+// report IsManifest (see providers.ManifestBody).
+func (c *CloudFiles) GetObject(ctx context.Context, container, name string) (io.ReadCloser, error) {
+	headRes, err := c.doRetry(ctx, func() (*http.Response, error) {
+		req, _ := http.NewRequest("HEAD", fmt.Sprintf("%s/%s/%s", c.endpoint, container, name), nil)
+		req.Header.Set("X-Auth-Token", c.token)
+		return c.client.Do(req.WithContext(ctx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	headRes.Body.Close()
+	if headRes.StatusCode != 200 {
+		return nil, fmt.Errorf("get object failed: status %d", headRes.StatusCode)
+	}
+	if headRes.Header.Get("X-Static-Large-Object") == "True" {
+		// Dynamic Large Objects (X-Object-Manifest) are reassembled by
+		// the proxy server on a plain GET, so only SLO manifests need
+		// client-side parallel segment fetching here.
+		return c.getLargeObject(ctx, container, name)
+	}
+
+	res, err := c.doRetry(ctx, func() (*http.Response, error) {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/%s/%s", c.endpoint, container, name), nil)
+		req.Header.Set("X-Auth-Token", c.token)
+		return c.client.Do(req.WithContext(ctx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, fmt.Errorf("get object failed: status %d", res.StatusCode)
+	}
+	if len(res.Header.Get("X-Object-Manifest")) > 0 {
+		return manifestReadCloser{res.Body}, nil
+	}
+	return res.Body, nil
+}
+
+// DeleteObject removes name from container.
+func (c *CloudFiles) DeleteObject(ctx context.Context, container, name string) error {
+	res, err := c.doRetry(ctx, func() (*http.Response, error) {
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/%s/%s", c.endpoint, container, name), nil)
+		req.Header.Set("X-Auth-Token", c.token)
+		return c.client.Do(req.WithContext(ctx))
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 204 && res.StatusCode != 200 {
+		return fmt.Errorf("delete object failed: status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// CopyObject copies srcName from srcContainer to dstName in dstContainer
+// using CloudFiles' server-side COPY-via-PUT support, so the object's
+// bytes never leave Rackspace's network.
+func (c *CloudFiles) CopyObject(ctx context.Context, srcContainer, srcName, dstContainer, dstName string) error {
+	res, err := c.doRetry(ctx, func() (*http.Response, error) {
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("%s/%s/%s", c.endpoint, dstContainer, dstName), nil)
+		req.Header.Set("X-Auth-Token", c.token)
+		req.Header.Set("X-Copy-From", fmt.Sprintf("/%s/%s", srcContainer, srcName))
+		req.ContentLength = 0
+		return c.client.Do(req.WithContext(ctx))
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 201 {
+		return fmt.Errorf("copy object failed: status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// CreateContainer ensures that container exists.
+func (c *CloudFiles) CreateContainer(ctx context.Context, container string) error {
+	res, err := c.doRetry(ctx, func() (*http.Response, error) {
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("%s/%s", c.endpoint, container), bytes.NewReader([]byte{}))
+		req.Header.Set("X-Auth-Token", c.token)
+		return c.client.Do(req.WithContext(ctx))
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 201 && res.StatusCode != 202 {
+		return fmt.Errorf("create container failed: status %d", res.StatusCode)
+	}
+	return nil
+}
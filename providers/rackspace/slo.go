@@ -0,0 +1,115 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package rackspace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+const sloDownloadConcurrency = 4
+
+type manifestSegment struct {
+	Name  string `json:"name"`
+	Hash  string `json:"hash"`
+	Bytes int64  `json:"bytes"`
+}
+
+// getLargeObject fetches the segments of the SLO manifest object name
+// in parallel and streams them back, in order, through a pipe.
+func (c *CloudFiles) getLargeObject(ctx context.Context, container, name string) (io.ReadCloser, error) {
+	res, err := c.doRetry(ctx, func() (*http.Response, error) {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/%s/%s?multipart-manifest=get", c.endpoint, container, name), nil)
+		req.Header.Set("X-Auth-Token", c.token)
+		return c.client.Do(req.WithContext(ctx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("get manifest failed: status %d", res.StatusCode)
+	}
+
+	var segments []manifestSegment
+	if err := json.Unmarshal(body, &segments); err != nil {
+		return nil, err
+	}
+
+	// Fetch at most sloDownloadConcurrency segments at a time and hand
+	// each response off to the reassembly goroutine below as soon as it
+	// arrives, rather than buffering every segment body in memory before
+	// streaming begins.
+	type segmentResult struct {
+		res *http.Response
+		err error
+	}
+	results := make([]chan segmentResult, len(segments))
+	for i := range results {
+		results[i] = make(chan segmentResult, 1)
+	}
+
+	sem := make(chan struct{}, sloDownloadConcurrency)
+	for i, seg := range segments {
+		sem <- struct{}{}
+		go func(index int, segmentPath string) {
+			defer func() { <-sem }()
+
+			segRes, err := c.doRetry(ctx, func() (*http.Response, error) {
+				segReq, _ := http.NewRequest("GET", fmt.Sprintf("%s/%s", c.endpoint, segmentPath), nil)
+				segReq.Header.Set("X-Auth-Token", c.token)
+				return c.client.Do(segReq.WithContext(ctx))
+			})
+			results[index] <- segmentResult{res: segRes, err: err}
+		}(i, seg.Name)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for _, ch := range results {
+			r := <-ch
+			if r.err != nil {
+				pw.CloseWithError(r.err)
+				return
+			}
+			_, err := io.Copy(pw, r.res.Body)
+			r.res.Body.Close()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	return manifestReadCloser{pr}, nil
+}
+
+// manifestReadCloser marks a GetObject body as reassembled from an
+// SLO/DLO manifest, so Download knows its listing ETag (see ListObjects)
+// is not a hash of this content (for an SLO it is md5(concatenation of
+// segment ETags), for a DLO the hash of the manifest object itself) and
+// must not verify it as one. See providers.ManifestBody.
+type manifestReadCloser struct {
+	io.ReadCloser
+}
+
+func (manifestReadCloser) IsManifest() bool { return true }
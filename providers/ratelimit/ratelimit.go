@@ -0,0 +1,102 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package ratelimit caps the aggregate upload/download throughput of a
+// gohaste run with a simple token bucket, shared across every worker
+// goroutine.
+package ratelimit
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket of bytes, refilled continuously at
+// bytesPerSec. A nil *Limiter imposes no limit.
+type Limiter struct {
+	mu          sync.Mutex
+	bytesPerSec float64
+	tokens      float64
+	last        time.Time
+}
+
+// New returns a Limiter permitting up to mbps megabits/sec, aggregated
+// across every caller of Wait. mbps <= 0 means unlimited, and New
+// returns nil in that case so callers can skip limiting entirely.
+func New(mbps float64) *Limiter {
+	if mbps <= 0 {
+		return nil
+	}
+	bytesPerSec := mbps * 1000 * 1000 / 8
+	return &Limiter{
+		bytesPerSec: bytesPerSec,
+		tokens:      bytesPerSec,
+		last:        time.Now(),
+	}
+}
+
+// Wait blocks until n bytes' worth of budget is available.
+func (l *Limiter) Wait(n int) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.bytesPerSec
+	if l.tokens > l.bytesPerSec {
+		l.tokens = l.bytesPerSec
+	}
+	l.last = now
+
+	need := float64(n)
+	var sleep time.Duration
+	if l.tokens < need {
+		sleep = time.Duration((need - l.tokens) / l.bytesPerSec * float64(time.Second))
+		l.tokens = 0
+	} else {
+		l.tokens -= need
+	}
+	l.mu.Unlock()
+
+	if sleep > 0 {
+		time.Sleep(sleep)
+	}
+}
+
+// reader wraps an io.Reader, consuming limiter budget for every chunk
+// read through it.
+type reader struct {
+	r       io.Reader
+	limiter *Limiter
+}
+
+// Reader returns r throttled to limiter's rate. If limiter is nil, r is
+// returned unchanged.
+func Reader(r io.Reader, limiter *Limiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+	return &reader{r: r, limiter: limiter}
+}
+
+func (lr *reader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		lr.limiter.Wait(n)
+	}
+	return n, err
+}
@@ -0,0 +1,58 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewUnlimited(t *testing.T) {
+	if l := New(0); l != nil {
+		t.Errorf("New(0) = %v, want nil", l)
+	}
+	if l := New(-1); l != nil {
+		t.Errorf("New(-1) = %v, want nil", l)
+	}
+}
+
+func TestNilLimiterWaitIsNoop(t *testing.T) {
+	var l *Limiter
+	start := time.Now()
+	l.Wait(1 << 30)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("nil Limiter.Wait blocked for %v, want immediate return", elapsed)
+	}
+}
+
+func TestWaitWithinBudgetDoesNotSleep(t *testing.T) {
+	l := New(80) // 10 MB/s
+	start := time.Now()
+	l.Wait(1024)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Wait within budget took %v, want near-instant", elapsed)
+	}
+}
+
+func TestWaitOverBudgetSleeps(t *testing.T) {
+	l := New(8)         // 1 MB/s, so a 1 MiB request beyond the initial full bucket sleeps
+	l.Wait(1024 * 1024) // drain the initial burst
+	start := time.Now()
+	l.Wait(1024 * 1024)
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("Wait over budget took %v, want it to block for roughly a second", elapsed)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package retry provides the retry-with-backoff policy shared by every
+// StorageProvider backend: transient HTTP failures (5xx, 429, connection
+// resets) are retried with exponential backoff and jitter, honoring any
+// Retry-After header, while a 401 is reported distinctly so callers can
+// re-authenticate and try again. Every retried attempt is also tallied
+// process-wide so a caller can report it in a final run summary.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// MaxAttempts is the number of times an operation is attempted before
+// giving up, including the initial attempt.
+const MaxAttempts = 5
+
+// BaseDelay is the starting delay for exponential backoff; it doubles on
+// each subsequent attempt, plus jitter.
+const BaseDelay = 500 * time.Millisecond
+
+// ErrUnauthorized is returned by a Do callback to signal that the
+// request failed with a 401 and the caller should re-authenticate before
+// Do retries.
+var ErrUnauthorized = errors.New("retry: unauthorized")
+
+// Attempts reports how many times an operation was actually tried, so
+// callers can total up retries for a final run summary.
+type Attempts int
+
+// totalRetries tallies every retried attempt across every provider call
+// in the process, so gohaste.go and sync.go can report it in the final
+// run summary.
+var totalRetries int64
+
+// Retries reports how many retried attempts have occurred so far in
+// this process.
+func Retries() int64 {
+	return atomic.LoadInt64(&totalRetries)
+}
+
+// Do calls fn until it succeeds, a non-retryable error is returned, or
+// MaxAttempts is reached. fn should wrap retryable errors (5xx, 429,
+// connection resets) with Retryable and return ErrUnauthorized on a 401
+// so Do's caller can reauth via onUnauthorized; any other error that
+// IsRetryable does not recognize is treated as permanent. retryAfter, when fn set it to a positive
+// duration, is honored as the wait before the next attempt instead of
+// the computed backoff.
+func Do(ctx context.Context, onUnauthorized func() error, fn func(attempt int) (retryAfter time.Duration, err error)) (Attempts, error) {
+	var lastErr error
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return Attempts(attempt - 1), err
+		}
+
+		retryAfter, err := fn(attempt)
+		if err == nil {
+			return Attempts(attempt), nil
+		}
+		lastErr = err
+
+		if err == ErrUnauthorized {
+			if onUnauthorized == nil {
+				return Attempts(attempt), err
+			}
+			if authErr := onUnauthorized(); authErr != nil {
+				return Attempts(attempt), authErr
+			}
+			atomic.AddInt64(&totalRetries, 1)
+			continue
+		}
+
+		if !IsRetryable(err) {
+			return Attempts(attempt), err
+		}
+
+		if attempt == MaxAttempts {
+			break
+		}
+
+		delay := retryAfter
+		if delay <= 0 {
+			delay = backoff(attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return Attempts(attempt), ctx.Err()
+		case <-time.After(delay):
+		}
+		atomic.AddInt64(&totalRetries, 1)
+	}
+	return MaxAttempts, lastErr
+}
+
+// retryableError marks an error as transient and worth retrying.
+type retryableError struct {
+	err error
+}
+
+func (r *retryableError) Error() string { return r.err.Error() }
+func (r *retryableError) Unwrap() error { return r.err }
+
+// Retryable wraps err so IsRetryable reports true for it.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err}
+}
+
+// IsRetryable reports whether err was produced by Retryable, or is a
+// network-level error worth retrying (e.g. a connection reset).
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var r *retryableError
+	if errors.As(err, &r) {
+		return true
+	}
+	// Errors surfaced directly by the net/http client (connection
+	// refused/reset, timeouts, EOF mid-response) are themselves worth
+	// retrying even though the provider didn't wrap them explicitly.
+	var netErr interface{ Timeout() bool }
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return false
+}
+
+// RetryableStatus reports whether an HTTP status code should be retried:
+// 429 and any 5xx.
+func RetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// RetryAfter parses a Retry-After response header (seconds, or an
+// HTTP-date) into a duration. It returns 0 if the header is absent or
+// unparseable, in which case the caller should fall back to backoff.
+func RetryAfter(h http.Header) time.Duration {
+	value := h.Get("Retry-After")
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if d := time.Until(when); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+func backoff(attempt int) time.Duration {
+	d := BaseDelay << uint(attempt-1)
+	jitter := time.Duration(rand.Int63n(int64(d) / 2))
+	return d + jitter
+}
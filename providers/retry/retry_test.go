@@ -0,0 +1,78 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package retry
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfterSeconds(t *testing.T) {
+	h := http.Header{}
+	h.Set("Retry-After", "5")
+	if got, want := RetryAfter(h), 5*time.Second; got != want {
+		t.Errorf("RetryAfter(%q) = %v, want %v", "5", got, want)
+	}
+}
+
+func TestRetryAfterHTTPDate(t *testing.T) {
+	when := time.Now().Add(10 * time.Second)
+	h := http.Header{}
+	h.Set("Retry-After", when.UTC().Format(http.TimeFormat))
+
+	got := RetryAfter(h)
+	if got <= 0 || got > 10*time.Second {
+		t.Errorf("RetryAfter(future HTTP-date) = %v, want a positive duration <= 10s", got)
+	}
+}
+
+func TestRetryAfterPastHTTPDate(t *testing.T) {
+	h := http.Header{}
+	h.Set("Retry-After", time.Now().Add(-10*time.Second).UTC().Format(http.TimeFormat))
+	if got := RetryAfter(h); got != 0 {
+		t.Errorf("RetryAfter(past HTTP-date) = %v, want 0", got)
+	}
+}
+
+func TestRetryAfterAbsent(t *testing.T) {
+	if got := RetryAfter(http.Header{}); got != 0 {
+		t.Errorf("RetryAfter(no header) = %v, want 0", got)
+	}
+}
+
+func TestRetryAfterUnparseable(t *testing.T) {
+	h := http.Header{}
+	h.Set("Retry-After", "not-a-duration")
+	if got := RetryAfter(h); got != 0 {
+		t.Errorf("RetryAfter(garbage) = %v, want 0", got)
+	}
+}
+
+func TestRetryableStatus(t *testing.T) {
+	cases := map[int]bool{
+		200: false,
+		404: false,
+		429: true,
+		500: true,
+		503: true,
+	}
+	for status, want := range cases {
+		if got := RetryableStatus(status); got != want {
+			t.Errorf("RetryableStatus(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package s3 implements providers.StorageProvider against Amazon S3,
+// signing requests with AWS Signature Version 4.
+package s3
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sivel/gohaste/providers"
+	"github.com/sivel/gohaste/providers/retry"
+)
+
+// S3 is a providers.StorageProvider backed by Amazon S3.
+type S3 struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Region          string
+
+	client *http.Client
+}
+
+// New returns an S3 provider for the given credentials and region.
+func New(accessKeyId, secretAccessKey, region string) *S3 {
+	if len(region) == 0 {
+		region = "us-east-1"
+	}
+	return &S3{
+		AccessKeyId:     accessKeyId,
+		SecretAccessKey: secretAccessKey,
+		Region:          region,
+		client:          &http.Client{},
+	}
+}
+
+// Auth is a no-op for S3: every request is signed individually with
+// SigV4, so there is no session token to obtain up front.
+func (s *S3) Auth(ctx context.Context) error {
+	if len(s.AccessKeyId) == 0 || len(s.SecretAccessKey) == 0 {
+		return fmt.Errorf("S3 access key id and secret access key are required")
+	}
+	return nil
+}
+
+func (s *S3) endpoint(bucket string) string {
+	if s.Region == "us-east-1" {
+		return fmt.Sprintf("https://%s.s3.amazonaws.com", bucket)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, s.Region)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sign attaches the Authorization, X-Amz-Date and X-Amz-Content-Sha256
+// headers required by SigV4 to req.
+func (s *S3) sign(req *http.Request, payload []byte) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("Host", req.URL.Host)
+
+	var headerNames []string
+	for name := range req.Header {
+		headerNames = append(headerNames, strings.ToLower(name))
+	}
+	headerNames = append(headerNames, "host")
+	sortStrings(headerNames)
+
+	var canonicalHeaders strings.Builder
+	var signedHeaders []string
+	seen := make(map[string]bool)
+	for _, name := range headerNames {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		var value string
+		if name == "host" {
+			value = req.URL.Host
+		} else {
+			value = req.Header.Get(name)
+		}
+		canonicalHeaders.WriteString(fmt.Sprintf("%s:%s\n", name, strings.TrimSpace(value)))
+		signedHeaders = append(signedHeaders, name)
+	}
+	signedHeadersStr := strings.Join(signedHeaders, ";")
+
+	// Pin the request's wire encoding to exactly what we sign: S3
+	// recomputes the canonical request from the bytes actually on the
+	// wire, so the path/query encoding here must match what net/http
+	// sends, not just what net/url would produce by default.
+	canonicalPath := canonicalURI(req.URL)
+	canonicalQuery := canonicalQueryString(req.URL)
+	req.URL.RawPath = canonicalPath
+	req.URL.RawQuery = canonicalQuery
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath,
+		canonicalQuery,
+		canonicalHeaders.String(),
+		signedHeadersStr,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", dateStamp, s.Region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	kDate := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), dateStamp)
+	kRegion := hmacSHA256(kDate, s.Region)
+	kService := hmacSHA256(kRegion, "s3")
+	kSigning := hmacSHA256(kService, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(kSigning, stringToSign))
+
+	authHeader := fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyId, scope, signedHeadersStr, signature,
+	)
+	req.Header.Set("Authorization", authHeader)
+}
+
+// isUnreservedURIByte reports whether b needs no percent-encoding under
+// the SigV4 URI-encoding rules (RFC 3986 unreserved characters).
+func isUnreservedURIByte(b byte) bool {
+	return (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+		b == '-' || b == '_' || b == '.' || b == '~'
+}
+
+// uriEncode percent-encodes s per the SigV4 spec: every byte except the
+// unreserved set is escaped as %XX with uppercase hex. '/' is left alone
+// when encodeSlash is false, as required when encoding a path segment
+// separator rather than a literal slash within a segment or query value.
+func uriEncode(s string, encodeSlash bool) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if isUnreservedURIByte(b) || (b == '/' && !encodeSlash) {
+			buf.WriteByte(b)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+	return buf.String()
+}
+
+// canonicalURI returns the canonical URI for u: each path segment
+// uri-encoded independently, with the separating slashes preserved.
+func canonicalURI(u *url.URL) string {
+	path := u.Path
+	if len(path) == 0 {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		segments[i] = uriEncode(seg, true)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQueryString returns u's query parameters uri-encoded and
+// sorted by key, then by value, as SigV4 requires.
+func canonicalQueryString(u *url.URL) string {
+	values := u.Query()
+	if len(values) == 0 {
+		return ""
+	}
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sortStrings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vs := values[k]
+		sortStrings(vs)
+		for _, v := range vs {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// sortStrings is a tiny insertion sort so this package has no dependency
+// beyond the standard library's sort for a handful of header names.
+func sortStrings(s []string) {
+	for i := 1; i < len(s); i++ {
+		for j := i; j > 0 && s[j] < s[j-1]; j-- {
+			s[j], s[j-1] = s[j-1], s[j]
+		}
+	}
+}
+
+// do sends a signed request, retrying transient failures with backoff.
+// S3 has no session to reauth, so a 401/403 is treated as permanent.
+func (s *S3) do(ctx context.Context, method, bucket, key, query string, body []byte) (*http.Response, error) {
+	reqURL := s.endpoint(bucket)
+	if len(key) > 0 {
+		reqURL = fmt.Sprintf("%s/%s", reqURL, key)
+	} else {
+		reqURL = reqURL + "/"
+	}
+	if len(query) > 0 {
+		reqURL = fmt.Sprintf("%s?%s", reqURL, query)
+	}
+
+	var res *http.Response
+	_, err := retry.Do(ctx, nil, func(attempt int) (time.Duration, error) {
+		var reader io.Reader
+		if body != nil {
+			reader = strings.NewReader(string(body))
+		}
+		req, err := http.NewRequest(method, reqURL, reader)
+		if err != nil {
+			return 0, err
+		}
+		s.sign(req, body)
+
+		res, err = s.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return 0, retry.Retryable(err)
+		}
+		if retry.RetryableStatus(res.StatusCode) {
+			retryAfter := retry.RetryAfter(res.Header)
+			res.Body.Close()
+			return retryAfter, retry.Retryable(fmt.Errorf("status %d", res.StatusCode))
+		}
+		return 0, nil
+	})
+	return res, err
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+		ETag string `xml:"ETag"`
+	} `xml:"Contents"`
+	IsTruncated bool   `xml:"IsTruncated"`
+	NextMarker  string `xml:"NextMarker"`
+}
+
+// ListObjects returns every object in bucket.
+func (s *S3) ListObjects(ctx context.Context, bucket string) ([]providers.Object, error) {
+	var objects []providers.Object
+	var marker string
+
+	for {
+		query := ""
+		if len(marker) > 0 {
+			query = fmt.Sprintf("marker=%s", marker)
+		}
+		res, err := s.do(ctx, "GET", bucket, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != 200 {
+			return nil, fmt.Errorf("list objects failed: status %d", res.StatusCode)
+		}
+
+		var result listBucketResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, providers.Object{
+				Name: c.Key,
+				Size: c.Size,
+				ETag: strings.Trim(c.ETag, `"`),
+			})
+			marker = c.Key
+		}
+		if !result.IsTruncated {
+			break
+		}
+	}
+
+	return objects, nil
+}
+
+// PutObject uploads key in bucket, calling getBody for the request body.
+// SigV4 signs over the full payload, so the body is buffered into memory
+// once rather than streamed, and every attempt resends that buffer.
+func (s *S3) PutObject(ctx context.Context, bucket, key string, getBody func() (io.ReadCloser, error)) error {
+	body, err := getBody()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(ctx, "PUT", bucket, key, "", data)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("put object failed: status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// GetObject returns a reader for key in bucket.
+func (s *S3) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	res, err := s.do(ctx, "GET", bucket, key, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, fmt.Errorf("get object failed: status %d", res.StatusCode)
+	}
+	return res.Body, nil
+}
+
+// DeleteObject removes key from bucket.
+func (s *S3) DeleteObject(ctx context.Context, bucket, key string) error {
+	res, err := s.do(ctx, "DELETE", bucket, key, "", nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 204 && res.StatusCode != 200 {
+		return fmt.Errorf("delete object failed: status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// CreateContainer ensures that bucket exists.
+func (s *S3) CreateContainer(ctx context.Context, bucket string) error {
+	res, err := s.do(ctx, "PUT", bucket, "", "", []byte{})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 && res.StatusCode != 409 {
+		return fmt.Errorf("create bucket failed: status %d", res.StatusCode)
+	}
+	return nil
+}
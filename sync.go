@@ -0,0 +1,288 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sivel/gohaste/progress"
+	"github.com/sivel/gohaste/providers"
+	"github.com/sivel/gohaste/providers/rackspace"
+	"github.com/sivel/gohaste/providers/retry"
+)
+
+// storageURL is a parsed provider://container/prefix argument to
+// `gohaste sync`.
+type storageURL struct {
+	Scheme    string
+	Container string
+	Prefix    string
+}
+
+// parseStorageURL parses a provider://container/prefix argument, e.g.
+// "cf://mybucket/data" or "s3://bucket/".
+func parseStorageURL(raw string) (*storageURL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return nil, fmt.Errorf("%s is not a valid provider://container/prefix URL", raw)
+	}
+	return &storageURL{
+		Scheme:    u.Scheme,
+		Container: u.Host,
+		Prefix:    strings.TrimPrefix(u.Path, "/"),
+	}, nil
+}
+
+// newProviderFromScheme builds the provider identified by a sync URL
+// scheme, reading credentials from that backend's usual environment
+// variables, the same way -provider does for upload/download/delete.
+func newProviderFromScheme(scheme string) providers.StorageProvider {
+	switch scheme {
+	case "cf":
+		return newProvider("rackspace", os.Getenv("OS_USERNAME"), os.Getenv("OS_PASSWORD"), os.Getenv("OS_REGION_NAME"))
+	case "s3":
+		return newProvider("s3", "", "", "")
+	case "gs":
+		return newProvider("gcs", "", "", "")
+	case "az":
+		return newProvider("azure", "", "", "")
+	case "b2":
+		return newProvider("b2", "", "", "")
+	case "oss":
+		return newProvider("oss", "", "", "")
+	default:
+		log.Fatal(fmt.Sprintf("%s is not a supported sync scheme", scheme))
+		return nil
+	}
+}
+
+// syncTask describes a single object that needs to move, or be deleted,
+// to bring the destination in line with the source.
+type syncTask struct {
+	Name   string
+	Delete bool
+}
+
+// objectsEqual reports whether dstObj already matches srcObj and so does
+// not need to be re-copied. ETags are only comparable when both sides
+// look like the same kind of content hash (an MD5-shaped ETag on one
+// provider and an opaque version token on another are not the same
+// thing); otherwise fall back to comparing size and, when both sides
+// report one, last-modified time.
+func objectsEqual(srcObj, dstObj providers.Object) bool {
+	if looksLikeMD5(srcObj.ETag) && looksLikeMD5(dstObj.ETag) {
+		return strings.EqualFold(srcObj.ETag, dstObj.ETag) && srcObj.Size == dstObj.Size
+	}
+	if srcObj.Size != dstObj.Size {
+		return false
+	}
+	if !srcObj.LastModified.IsZero() && !dstObj.LastModified.IsZero() {
+		return !dstObj.LastModified.Before(srcObj.LastModified)
+	}
+	return true
+}
+
+// planSync lists both sides of a sync and returns the objects that are
+// missing or changed on the destination (compared by name + ETag/size),
+// plus, when deleteExtra is set, the destination objects absent from the
+// source.
+func planSync(ctx context.Context, src, dst providers.StorageProvider, srcURL, dstURL *storageURL, deleteExtra bool) ([]syncTask, error) {
+	srcObjects, err := src.ListObjects(ctx, srcURL.Container)
+	if err != nil {
+		return nil, err
+	}
+	dstObjects, err := dst.ListObjects(ctx, dstURL.Container)
+	if err != nil {
+		return nil, err
+	}
+
+	srcByName := make(map[string]providers.Object)
+	for _, o := range srcObjects {
+		if strings.HasPrefix(o.Name, srcURL.Prefix) {
+			srcByName[strings.TrimPrefix(o.Name, srcURL.Prefix)] = o
+		}
+	}
+	dstByName := make(map[string]providers.Object)
+	for _, o := range dstObjects {
+		if strings.HasPrefix(o.Name, dstURL.Prefix) {
+			dstByName[strings.TrimPrefix(o.Name, dstURL.Prefix)] = o
+		}
+	}
+
+	var tasks []syncTask
+	for name, srcObj := range srcByName {
+		dstObj, ok := dstByName[name]
+		if !ok || !objectsEqual(srcObj, dstObj) {
+			tasks = append(tasks, syncTask{Name: name})
+		}
+	}
+	if deleteExtra {
+		for name := range dstByName {
+			if _, ok := srcByName[name]; !ok {
+				tasks = append(tasks, syncTask{Name: name, Delete: true})
+			}
+		}
+	}
+
+	return tasks, nil
+}
+
+// sameRegion reports whether src and dst are the same Rackspace CloudFiles
+// region, which server-side copy requires since it is scoped to one
+// region's object store. Any pair that is not two CloudFiles providers
+// reports true; the regions of other providers are not compared.
+func sameRegion(src, dst providers.StorageProvider) bool {
+	srcCF, ok := src.(*rackspace.CloudFiles)
+	if !ok {
+		return true
+	}
+	dstCF, ok := dst.(*rackspace.CloudFiles)
+	if !ok {
+		return true
+	}
+	return srcCF.Region == dstCF.Region
+}
+
+// syncWorker copies or deletes objects named on ti, preferring a
+// provider-native server-side copy when src and dst are the same
+// provider.
+func syncWorker(ctx context.Context, thread int, ti chan syncTask, wg *sync.WaitGroup, src, dst providers.StorageProvider, srcURL, dstURL *storageURL, dryRun bool, cnt *counters, reporter progress.Reporter) {
+	defer wg.Done()
+
+	copier, supportsServerSideCopy := src.(providers.ServerSideCopier)
+	useServerSideCopy := supportsServerSideCopy && srcURL.Scheme == dstURL.Scheme && sameRegion(src, dst)
+
+	for task := range ti {
+		srcName := srcURL.Prefix + task.Name
+		dstName := dstURL.Prefix + task.Name
+
+		if task.Delete {
+			reporter.Start(thread, dstName, 0)
+			if dryRun {
+				reporter.Done(thread, dstName)
+				continue
+			}
+			if err := dst.DeleteObject(ctx, dstURL.Container, dstName); err != nil {
+				reporter.Error(thread, dstName, err)
+				cnt.fail()
+				continue
+			}
+			reporter.Done(thread, dstName)
+			cnt.ok()
+			continue
+		}
+
+		reporter.Start(thread, dstName, 0)
+		if dryRun {
+			reporter.Done(thread, dstName)
+			continue
+		}
+
+		if useServerSideCopy {
+			if err := copier.CopyObject(ctx, srcURL.Container, srcName, dstURL.Container, dstName); err != nil {
+				reporter.Error(thread, dstName, err)
+				cnt.fail()
+				continue
+			}
+			reporter.Done(thread, dstName)
+			cnt.ok()
+			continue
+		}
+
+		getBody := func() (io.ReadCloser, error) {
+			body, err := src.GetObject(ctx, srcURL.Container, srcName)
+			if err != nil {
+				return nil, err
+			}
+			return struct {
+				io.Reader
+				io.Closer
+			}{progress.Reader(body, reporter, thread, dstName), body}, nil
+		}
+		if err := dst.PutObject(ctx, dstURL.Container, dstName, getBody); err != nil {
+			reporter.Error(thread, dstName, err)
+			cnt.fail()
+			continue
+		}
+		reporter.Done(thread, dstName)
+		cnt.ok()
+	}
+}
+
+// runSync implements `gohaste sync <src-url> <dst-url>`.
+func runSync(ctx context.Context, rawSrc, rawDst string, concurrency int, deleteExtra, dryRun bool, logFormat string) error {
+	srcURL, err := parseStorageURL(rawSrc)
+	if err != nil {
+		return err
+	}
+	dstURL, err := parseStorageURL(rawDst)
+	if err != nil {
+		return err
+	}
+
+	src := newProviderFromScheme(srcURL.Scheme)
+	if err := src.Auth(ctx); err != nil {
+		return err
+	}
+	dst := newProviderFromScheme(dstURL.Scheme)
+	if err := dst.Auth(ctx); err != nil {
+		return err
+	}
+
+	if err := dst.CreateContainer(ctx, dstURL.Container); err != nil {
+		return err
+	}
+
+	tasks, err := planSync(ctx, src, dst, srcURL, dstURL, deleteExtra)
+	if err != nil {
+		return err
+	}
+
+	reporter := progress.New(logFormat, os.Stdout)
+	reporter.SetTotals(len(tasks), 0)
+
+	ti := make(chan syncTask, concurrency)
+	wg := new(sync.WaitGroup)
+	cnt := &counters{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go syncWorker(ctx, i, ti, wg, src, dst, srcURL, dstURL, dryRun, cnt, reporter)
+	}
+
+	for _, task := range tasks {
+		ti <- task
+	}
+	close(ti)
+
+	wg.Wait()
+	reporter.Close()
+
+	fmt.Printf("\n%d succeeded, %d failed, %d retried\n", cnt.successes, cnt.failures, retry.Retries())
+	if cnt.failures > 0 {
+		return fmt.Errorf("sync completed with %d failure(s)", cnt.failures)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/sivel/gohaste/providers"
+	"github.com/sivel/gohaste/providers/rackspace"
+)
+
+func TestParseStorageURL(t *testing.T) {
+	cases := []struct {
+		raw     string
+		want    *storageURL
+		wantErr bool
+	}{
+		{"cf://mybucket/data", &storageURL{Scheme: "cf", Container: "mybucket", Prefix: "data"}, false},
+		{"s3://bucket/", &storageURL{Scheme: "s3", Container: "bucket", Prefix: ""}, false},
+		{"s3://bucket", &storageURL{Scheme: "s3", Container: "bucket", Prefix: ""}, false},
+		{"not-a-url", nil, true},
+		{"://missing-scheme", nil, true},
+	}
+	for _, c := range cases {
+		got, err := parseStorageURL(c.raw)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseStorageURL(%q) = %+v, nil, want an error", c.raw, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseStorageURL(%q) returned error: %v", c.raw, err)
+			continue
+		}
+		if *got != *c.want {
+			t.Errorf("parseStorageURL(%q) = %+v, want %+v", c.raw, *got, *c.want)
+		}
+	}
+}
+
+// fakeProvider is a minimal providers.StorageProvider backed by an
+// in-memory object listing, just enough for planSync to exercise.
+type fakeProvider struct {
+	objects []providers.Object
+}
+
+func (f *fakeProvider) Auth(ctx context.Context) error { return nil }
+
+func (f *fakeProvider) ListObjects(ctx context.Context, container string) ([]providers.Object, error) {
+	return f.objects, nil
+}
+
+func (f *fakeProvider) PutObject(ctx context.Context, container, name string, getBody func() (io.ReadCloser, error)) error {
+	return nil
+}
+
+func (f *fakeProvider) GetObject(ctx context.Context, container, name string) (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (f *fakeProvider) DeleteObject(ctx context.Context, container, name string) error { return nil }
+
+func (f *fakeProvider) CreateContainer(ctx context.Context, container string) error { return nil }
+
+func taskNames(tasks []syncTask) []string {
+	var names []string
+	for _, t := range tasks {
+		names = append(names, t.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestPlanSyncCopiesMissingAndChanged(t *testing.T) {
+	src := &fakeProvider{objects: []providers.Object{
+		{Name: "a", ETag: "d41d8cd98f00b204e9800998ecf8427e", Size: 1},
+		{Name: "b", ETag: "0cc175b9c0f1b6a831c399e269772661", Size: 2},
+		{Name: "c", ETag: "92eb5ffee6ae2fec3ad71c777531578f", Size: 3},
+	}}
+	dst := &fakeProvider{objects: []providers.Object{
+		{Name: "a", ETag: "d41d8cd98f00b204e9800998ecf8427e", Size: 1}, // unchanged, should not be re-copied
+		{Name: "b", ETag: "c81e728d9d4c2f636f067f89cc14862c", Size: 2}, // MD5 mismatch, should be re-copied
+	}}
+	srcURL := &storageURL{Scheme: "s3", Container: "src"}
+	dstURL := &storageURL{Scheme: "s3", Container: "dst"}
+
+	tasks, err := planSync(context.Background(), src, dst, srcURL, dstURL, false)
+	if err != nil {
+		t.Fatalf("planSync returned error: %v", err)
+	}
+	if got, want := taskNames(tasks), []string{"b", "c"}; !equalStrings(got, want) {
+		t.Errorf("planSync tasks = %v, want %v", got, want)
+	}
+}
+
+func TestPlanSyncOpaqueETagsFallBackToSize(t *testing.T) {
+	// Azure-style opaque version-token ETags are not comparable across
+	// providers, so an unchanged object with a different-looking ETag on
+	// each side must still be recognized as already in sync by size.
+	src := &fakeProvider{objects: []providers.Object{
+		{Name: "a", ETag: "\"0x8D1234567890ABC\"", Size: 10},
+		{Name: "b", ETag: "\"0x8D1234567890DEF\"", Size: 20},
+	}}
+	dst := &fakeProvider{objects: []providers.Object{
+		{Name: "a", ETag: "\"0xAZUREOPAQUE1\"", Size: 10}, // same size, opaque ETag differs: in sync
+		{Name: "b", ETag: "\"0xAZUREOPAQUE2\"", Size: 99}, // size differs: needs copy
+	}}
+	srcURL := &storageURL{Scheme: "s3", Container: "src"}
+	dstURL := &storageURL{Scheme: "azure", Container: "dst"}
+
+	tasks, err := planSync(context.Background(), src, dst, srcURL, dstURL, false)
+	if err != nil {
+		t.Fatalf("planSync returned error: %v", err)
+	}
+	if got, want := taskNames(tasks), []string{"b"}; !equalStrings(got, want) {
+		t.Errorf("planSync tasks = %v, want %v", got, want)
+	}
+}
+
+func TestPlanSyncDeleteExtra(t *testing.T) {
+	src := &fakeProvider{objects: []providers.Object{
+		{Name: "a", ETag: "etag-a", Size: 1},
+	}}
+	dst := &fakeProvider{objects: []providers.Object{
+		{Name: "a", ETag: "etag-a", Size: 1},
+		{Name: "stale", ETag: "etag-x", Size: 1},
+	}}
+	srcURL := &storageURL{Scheme: "s3", Container: "src"}
+	dstURL := &storageURL{Scheme: "s3", Container: "dst"}
+
+	tasks, err := planSync(context.Background(), src, dst, srcURL, dstURL, true)
+	if err != nil {
+		t.Fatalf("planSync returned error: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].Name != "stale" || !tasks[0].Delete {
+		t.Errorf("planSync with deleteExtra tasks = %+v, want a single Delete task for %q", tasks, "stale")
+	}
+}
+
+func TestSameRegion(t *testing.T) {
+	dfw := rackspace.New("user", "key", "DFW")
+	ord := rackspace.New("user", "key", "ORD")
+	s3Provider := &fakeProvider{}
+
+	if !sameRegion(dfw, rackspace.New("user", "key", "DFW")) {
+		t.Error("sameRegion(DFW, DFW) = false, want true")
+	}
+	if sameRegion(dfw, ord) {
+		t.Error("sameRegion(DFW, ORD) = true, want false")
+	}
+	if !sameRegion(s3Provider, s3Provider) {
+		t.Error("sameRegion for a non-Rackspace provider = false, want true (region is not its concept)")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}